@@ -3,6 +3,10 @@ package command_factory
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
 	"sort"
 	"strconv"
 	"strings"
@@ -12,9 +16,11 @@ import (
 	"github.com/cloudfoundry-incubator/lattice/ltc/app_runner"
 	"github.com/cloudfoundry-incubator/lattice/ltc/app_runner/docker_metadata_fetcher"
 	"github.com/cloudfoundry-incubator/lattice/ltc/docker_runner"
+	"github.com/cloudfoundry-incubator/lattice/ltc/docker_runner/manifest"
 	"github.com/cloudfoundry-incubator/lattice/ltc/exit_handler"
 	"github.com/cloudfoundry-incubator/lattice/ltc/exit_handler/exit_codes"
 	"github.com/cloudfoundry-incubator/lattice/ltc/logs/console_tailed_logs_outputter"
+	"github.com/cloudfoundry-incubator/lattice/ltc/route_helpers"
 	"github.com/cloudfoundry-incubator/lattice/ltc/terminal"
 	"github.com/cloudfoundry-incubator/lattice/ltc/terminal/colors"
 	"github.com/codegangsta/cli"
@@ -25,10 +31,27 @@ import (
 type pollingAction string
 
 const (
-	InvalidPortErrorMessage          = "Invalid port specified. Ports must be a comma-delimited list of integers between 0-65535."
-	MalformedRouteErrorMessage       = "Malformed route. Routes must be of the format port:route"
-	MustSetMonitoredPortErrorMessage = "Must set monitor-port when specifying multiple exposed ports unless --no-monitor is set."
-	MonitorPortNotExposed            = "Must have an exposed port that matches the monitored port"
+	InvalidPortErrorMessage                = "Invalid port specified. Ports must be a comma-delimited list of integers between 0-65535."
+	MalformedRouteErrorMessage              = "Malformed route. Routes must be of the format port:route"
+	MustSetMonitoredPortErrorMessage        = "Must set monitor-port when specifying multiple exposed ports unless --no-monitor is set."
+	MonitorPortNotExposed                  = "Must have an exposed port that matches the monitored port"
+	MonitorCommandEmptyErrorMessage         = "Must set a non-empty command when specifying --monitor-command."
+	MalformedTCPRouteErrorMessage           = "Malformed TCP route. TCP routes must be of the format external-port:container-port"
+	TCPRoutePortNotExposed                  = "Must have an exposed port that matches the container port in a --tcp-route"
+	TCPRouteExternalPortCollisionErrorMessage = "--tcp-route external ports must be unique and cannot collide with the reserved HTTP router ports (80, 443)."
+	RoutesDeprecationMessage               = "--routes is deprecated and will be removed in a future version. Use --http-route instead."
+	UserConflictsWithRunAsRootErrorMessage  = "Cannot set --user and --run-as-root at the same time. --run-as-root is an alias for --user=root."
+	InvalidPullPolicyErrorMessage           = "Invalid --pull-policy. Must be one of: always, missing, never."
+	DigestMismatchErrorMessage              = "The resolved digest for the image does not match --image-digest."
+	NoCachedDigestErrorMessage              = "No cached digest available for this image and --pull-policy=never was set."
+	AppAlreadyExistsErrorMessage            = "already exists, skipping"
+	UnsupportedSchemaVersionErrorMessage    = "Unsupported schemaVersion in --from-json manifest. Only schemaVersion: 1 is supported."
+	MalformedWaitForErrorMessage            = "Malformed --wait-for. Must be of the format http:PATH or tcp:PORT"
+	InvalidRouteServiceURLErrorMessage      = "Invalid --route-service-url. Must be a valid https:// URL."
+	NoMonitorConflictsWithMonitorCommandErrorMessage = "Cannot set --no-monitor and --monitor-command at the same time."
+
+	waitForMinBackoff time.Duration = 250 * time.Millisecond
+	waitForMaxBackoff time.Duration = 5 * time.Second
 
 	DefaultPollingTimeout time.Duration = 2 * time.Minute
 
@@ -36,6 +59,18 @@ const (
 	pollingScale pollingAction = "scale"
 )
 
+// httpGetter is the subset of *http.Client used to probe --wait-for=http:PATH,
+// narrowed so tests can stub it without spinning up a real listener.
+type httpGetter interface {
+	Get(url string) (*http.Response, error)
+}
+
+// sshConnector is the subset of ssh.SecureShell used by --ssh to drop a user
+// into a running instance right after create, narrowed so tests can stub it.
+type sshConnector interface {
+	ConnectToShell(appName string, instanceIndex int, command string) error
+}
+
 type DockerRunnerCommandFactory struct {
 	appRunner             app_runner.AppRunner
 	dockerAppRunner       docker_runner.DockerRunner
@@ -47,6 +82,8 @@ type DockerRunnerCommandFactory struct {
 	clock                 clock.Clock
 	tailedLogsOutputter   console_tailed_logs_outputter.TailedLogsOutputter
 	exitHandler           exit_handler.ExitHandler
+	httpClient            httpGetter
+	ssh                   sshConnector
 }
 
 type DockerRunnerCommandFactoryConfig struct {
@@ -61,9 +98,16 @@ type DockerRunnerCommandFactoryConfig struct {
 	Logger                lager.Logger
 	TailedLogsOutputter   console_tailed_logs_outputter.TailedLogsOutputter
 	ExitHandler           exit_handler.ExitHandler
+	HTTPClient            httpGetter
+	SSH                   sshConnector
 }
 
 func NewDockerRunnerCommandFactory(config DockerRunnerCommandFactoryConfig) *DockerRunnerCommandFactory {
+	httpClient := config.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
 	return &DockerRunnerCommandFactory{
 		appRunner:       config.AppRunner,
 		dockerAppRunner: config.DockerRunner,
@@ -75,6 +119,8 @@ func NewDockerRunnerCommandFactory(config DockerRunnerCommandFactoryConfig) *Doc
 		clock:                 config.Clock,
 		tailedLogsOutputter:   config.TailedLogsOutputter,
 		exitHandler:           config.ExitHandler,
+		httpClient:            httpClient,
+		ssh:                   config.SSH,
 	}
 }
 
@@ -88,11 +134,74 @@ func (factory *DockerRunnerCommandFactory) MakeCreateAppCommand() cli.Command {
 		},
 		cli.BoolFlag{
 			Name:  "run-as-root, r",
-			Usage: "Runs in the context of the root user",
+			Usage: "Alias for --user=root",
+		},
+		cli.StringFlag{
+			Name:  "user, u",
+			Usage: "Runs in the context of the given user (name or uid[:gid]), overriding the image metadata",
+		},
+		cli.BoolFlag{
+			Name:  "privileged",
+			Usage: "Runs the container with the full host capability set, independent of --user",
+		},
+		cli.StringFlag{
+			Name:  "pull-policy",
+			Usage: "Controls when the image is re-resolved to a digest: always, missing, or never",
+			Value: "always",
+		},
+		cli.StringFlag{
+			Name:  "image-digest",
+			Usage: "Pins the app to an explicit sha256:... content digest instead of resolving one from the registry",
+		},
+		cli.BoolFlag{
+			Name:  "pin-digest",
+			Usage: "Resolves the image tag to its current registry digest and pins the app to it, printing the resolved digest",
+		},
+		cli.StringFlag{
+			Name:  "manifest",
+			Usage: "Creates one or more apps described by a YAML manifest file. Flags passed alongside override manifest values.",
+		},
+		cli.StringFlag{
+			Name:  "from-json",
+			Usage: "Creates an app from a versioned JSON spec file, e.g. ltc create --from-json app.json",
+		},
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Prints the resolved --from-json app spec and exits without creating it",
+		},
+		cli.StringFlag{
+			Name: "wait-for",
+			Usage: "Waits for the app to respond before declaring it running, in addition to instance-count polling:\n\t\t" +
+				"--wait-for=http:/healthz or --wait-for=tcp:8080",
+		},
+		cli.BoolFlag{
+			Name:  "diagnose-placement",
+			Usage: "On a placement error, also renders the cluster's cell distribution. Defaults to on for interactive terminals.",
+		},
+		cli.BoolFlag{
+			Name:  "ssh",
+			Usage: "Opens an interactive shell to the app once it is running, e.g. ltc create --ssh myapp some/image",
+		},
+		cli.IntFlag{
+			Name:  "ssh-instance",
+			Usage: "Instance index to SSH into, used with --ssh",
+			Value: 0,
+		},
+		cli.StringFlag{
+			Name:  "route-service-url",
+			Usage: "Binds an upstream route service (must be https://...) to the app's routes. Pass \"\" to explicitly clear it.",
 		},
 		cli.StringSliceFlag{
-			Name:  "env, e",
-			Usage: "Environment variables (can be passed multiple times)",
+			Name: "env, e",
+			Usage: "Environment variables (can be passed multiple times):\n\t\t" +
+				"-e FOO=BAR sets FOO to BAR\n\t\t" +
+				"-e FOO imports FOO from the ltc process environment\n\t\t" +
+				"-e FOO=@/path/to/file inlines the file's contents as the value of FOO",
+			Value: &cli.StringSlice{},
+		},
+		cli.StringSliceFlag{
+			Name:  "env-file",
+			Usage: "Reads KEY=VALUE environment variables from a file (can be passed multiple times), overridden by --env",
 			Value: &cli.StringSlice{},
 		},
 		cli.IntFlag{
@@ -123,6 +232,10 @@ func (factory *DockerRunnerCommandFactory) MakeCreateAppCommand() cli.Command {
 			Usage: "Uses HTTP to healthcheck the app\n\t\t" +
 				"format is: port:/path/to/endpoint",
 		},
+		cli.StringFlag{
+			Name:  "monitor-command",
+			Usage: "Runs a command inside the container to healthcheck the app",
+		},
 		cli.DurationFlag{
 			Name:  "monitor-timeout",
 			Usage: "Timeout for the app healthcheck",
@@ -130,9 +243,21 @@ func (factory *DockerRunnerCommandFactory) MakeCreateAppCommand() cli.Command {
 		},
 		cli.StringFlag{
 			Name: "routes, R",
-			Usage: "Route mappings to exposed ports as follows:\n\t\t" +
+			Usage: "Deprecated, use --http-route instead. Route mappings to exposed ports as follows:\n\t\t" +
 				"--routes=80:web,8080:api will route web to 80 and api to 8080",
 		},
+		cli.StringSliceFlag{
+			Name: "http-route",
+			Usage: "HTTP route mappings to exposed ports as follows (can be passed multiple times):\n\t\t" +
+				"--http-route=80:web will route web to 80",
+			Value: &cli.StringSlice{},
+		},
+		cli.StringSliceFlag{
+			Name: "tcp-route",
+			Usage: "TCP route mappings of a router-assigned external port to a container port as follows (can be passed multiple times):\n\t\t" +
+				"--tcp-route=50000:5432 will route external port 50000 to container port 5432",
+			Value: &cli.StringSlice{},
+		},
 		cli.IntFlag{
 			Name:  "instances, i",
 			Usage: "Number of application instances to spawn on launch",
@@ -185,8 +310,19 @@ func (factory *DockerRunnerCommandFactory) MakeCreateAppCommand() cli.Command {
 }
 
 func (factory *DockerRunnerCommandFactory) createApp(context *cli.Context) {
+	if manifestPath := context.String("manifest"); manifestPath != "" {
+		factory.createAppsFromManifest(context, manifestPath)
+		return
+	}
+
+	if fromJSONPath := context.String("from-json"); fromJSONPath != "" {
+		factory.createAppFromJSON(context, fromJSONPath, context.Bool("dry-run"))
+		return
+	}
+
 	workingDirFlag := context.String("working-dir")
 	envVarsFlag := context.StringSlice("env")
+	envFilesFlag := context.StringSlice("env-file")
 	instancesFlag := context.Int("instances")
 	cpuWeightFlag := uint(context.Int("cpu-weight"))
 	memoryMBFlag := context.Int("memory-mb")
@@ -195,10 +331,23 @@ func (factory *DockerRunnerCommandFactory) createApp(context *cli.Context) {
 	noMonitorFlag := context.Bool("no-monitor")
 	portMonitorFlag := context.Int("monitor-port")
 	urlMonitorFlag := context.String("monitor-url")
+	commandMonitorFlag := context.String("monitor-command")
 	monitorTimeoutFlag := context.Duration("monitor-timeout")
 	routesFlag := context.String("routes")
+	httpRouteFlag := context.StringSlice("http-route")
+	tcpRouteFlag := context.StringSlice("tcp-route")
 	noRoutesFlag := context.Bool("no-routes")
 	timeoutFlag := context.Duration("timeout")
+	userFlag := context.String("user")
+	runAsRootFlag := context.Bool("run-as-root")
+	privilegedFlag := context.Bool("privileged")
+	pullPolicyFlag := context.String("pull-policy")
+	imageDigestFlag := context.String("image-digest")
+	pinDigestFlag := context.Bool("pin-digest")
+	waitForFlag := context.String("wait-for")
+	sshFlag := context.Bool("ssh")
+	sshInstanceFlag := context.Int("ssh-instance")
+	routeServiceURLFlag := context.String("route-service-url")
 	name := context.Args().Get(0)
 	dockerImage := context.Args().Get(1)
 	terminator := context.Args().Get(2)
@@ -217,12 +366,36 @@ func (factory *DockerRunnerCommandFactory) createApp(context *cli.Context) {
 		return
 	case len(context.Args()) > 4:
 		appArgs = context.Args()[4:]
-	case cpuWeightFlag < 1 || cpuWeightFlag > 100:
+	}
+
+	// Each of these is independent of the others and of the switch above, so
+	// they're checked as standalone ifs rather than additional switch cases -
+	// a switch only runs its first matching case, which would otherwise let
+	// len(context.Args()) > 4 mask the rest.
+	if cpuWeightFlag < 1 || cpuWeightFlag > 100 {
 		factory.ui.SayIncorrectUsage("Invalid CPU Weight")
 		factory.exitHandler.Exit(exit_codes.InvalidSyntax)
 		return
 	}
 
+	if userFlag != "" && context.IsSet("run-as-root") {
+		factory.ui.SayIncorrectUsage(UserConflictsWithRunAsRootErrorMessage)
+		factory.exitHandler.Exit(exit_codes.InvalidSyntax)
+		return
+	}
+
+	if pullPolicyFlag != "always" && pullPolicyFlag != "missing" && pullPolicyFlag != "never" {
+		factory.ui.SayIncorrectUsage(InvalidPullPolicyErrorMessage)
+		factory.exitHandler.Exit(exit_codes.InvalidSyntax)
+		return
+	}
+
+	if noMonitorFlag && commandMonitorFlag != "" {
+		factory.ui.SayIncorrectUsage(NoMonitorConflictsWithMonitorCommandErrorMessage)
+		factory.exitHandler.Exit(exit_codes.InvalidSyntax)
+		return
+	}
+
 	imageMetadata, err := factory.dockerMetadataFetcher.FetchMetadata(dockerImage)
 	if err != nil {
 		factory.ui.Say(fmt.Sprintf("Error fetching image metadata: %s", err))
@@ -230,6 +403,25 @@ func (factory *DockerRunnerCommandFactory) createApp(context *cli.Context) {
 		return
 	}
 
+	pinnedRootFS, err := factory.resolvePinnedRootFS(dockerImage, pullPolicyFlag, imageDigestFlag, imageMetadata)
+	if err != nil {
+		factory.ui.Say(err.Error())
+		factory.exitHandler.Exit(exit_codes.BadDocker)
+		return
+	}
+
+	if pinDigestFlag {
+		repo, tag := parseRepoTag(dockerImage)
+		digest, err := factory.dockerMetadataFetcher.FetchDigest(repo, tag)
+		if err != nil {
+			factory.ui.Say(fmt.Sprintf("Error resolving digest for image: %s", err))
+			factory.exitHandler.Exit(exit_codes.BadDocker)
+			return
+		}
+		factory.ui.Say(fmt.Sprintf("Resolved %s:%s -> %s@%s\n", repo, tag, repo, digest))
+		pinnedRootFS = dockerImageWithDigest(dockerImage, digest)
+	}
+
 	exposedPorts, err := factory.getExposedPortsFromArgs(portsFlag, imageMetadata)
 	if err != nil {
 		factory.ui.Say(err.Error())
@@ -237,7 +429,7 @@ func (factory *DockerRunnerCommandFactory) createApp(context *cli.Context) {
 		return
 	}
 
-	monitorConfig, err := factory.getMonitorConfigFromArgs(exposedPorts, portMonitorFlag, noMonitorFlag, urlMonitorFlag, monitorTimeoutFlag, imageMetadata)
+	monitorConfig, err := factory.getMonitorConfigFromArgs(exposedPorts, portMonitorFlag, noMonitorFlag, urlMonitorFlag, commandMonitorFlag, monitorTimeoutFlag, imageMetadata)
 	if err != nil {
 		factory.ui.Say(err.Error())
 		if err.Error() == MonitorPortNotExposed {
@@ -259,10 +451,13 @@ func (factory *DockerRunnerCommandFactory) createApp(context *cli.Context) {
 		}
 	}
 
-	if !noMonitorFlag {
-		factory.ui.Say(fmt.Sprintf("Monitoring the app on port %d...\n", monitorConfig.Port))
-	} else {
+	switch {
+	case noMonitorFlag:
 		factory.ui.Say("No ports will be monitored.\n")
+	case monitorConfig.Method == app_runner.CommandMonitor:
+		factory.ui.Say(fmt.Sprintf("Monitoring the app with the command %s...\n", monitorConfig.Command))
+	default:
+		factory.ui.Say(fmt.Sprintf("Monitoring the app on port %d...\n", monitorConfig.Port))
 	}
 
 	if startCommand == "" {
@@ -281,7 +476,50 @@ func (factory *DockerRunnerCommandFactory) createApp(context *cli.Context) {
 		appArgs = imageMetadata.StartCommand[1:]
 	}
 
-	routeOverrides, err := parseRouteOverrides(routesFlag)
+	var routeOverrides app_runner.RouteOverrides
+	if len(httpRouteFlag) > 0 {
+		routeOverrides, err = parseHTTPRouteOverrides(httpRouteFlag)
+	} else {
+		routeOverrides, err = parseRouteOverrides(routesFlag)
+		if err == nil && routesFlag != "" {
+			factory.ui.SayLine(RoutesDeprecationMessage)
+		}
+	}
+	if err != nil {
+		factory.ui.Say(err.Error())
+		factory.exitHandler.Exit(exit_codes.InvalidSyntax)
+		return
+	}
+
+	tcpRoutes, err := parseTCPRouteOverrides(tcpRouteFlag, exposedPorts)
+	if err != nil {
+		factory.ui.Say(err.Error())
+		if err.Error() == TCPRoutePortNotExposed {
+			factory.exitHandler.Exit(exit_codes.CommandFailed)
+		} else {
+			factory.exitHandler.Exit(exit_codes.InvalidSyntax)
+		}
+		return
+	}
+
+	routeServiceURL := routeServiceURLFlag
+	if context.IsSet("route-service-url") && routeServiceURL != "" {
+		if err := validateRouteServiceURL(routeServiceURL); err != nil {
+			factory.ui.Say(err.Error())
+			factory.exitHandler.Exit(exit_codes.InvalidSyntax)
+			return
+		}
+	}
+
+	effectiveUser := userFlag
+	if effectiveUser == "" && runAsRootFlag {
+		effectiveUser = "root"
+	}
+	if effectiveUser == "" {
+		effectiveUser = imageMetadata.User
+	}
+
+	environmentVariables, err := factory.buildEnvironment(envVarsFlag, envFilesFlag, name, imageMetadata)
 	if err != nil {
 		factory.ui.Say(err.Error())
 		factory.exitHandler.Exit(exit_codes.InvalidSyntax)
@@ -290,11 +528,12 @@ func (factory *DockerRunnerCommandFactory) createApp(context *cli.Context) {
 
 	err = factory.dockerAppRunner.CreateDockerApp(app_runner.CreateAppParams{
 		Name:                 name,
-		RootFS:               dockerImage,
+		RootFS:               pinnedRootFS,
 		StartCommand:         startCommand,
 		AppArgs:              appArgs,
-		EnvironmentVariables: factory.buildEnvironment(envVarsFlag, name),
-		Privileged:           context.Bool("run-as-root"),
+		EnvironmentVariables: environmentVariables,
+		Privileged:           privilegedFlag,
+		User:                 effectiveUser,
 		Monitor:              monitorConfig,
 		Instances:            instancesFlag,
 		CPUWeight:            cpuWeightFlag,
@@ -303,6 +542,8 @@ func (factory *DockerRunnerCommandFactory) createApp(context *cli.Context) {
 		ExposedPorts:         exposedPorts,
 		WorkingDir:           workingDirFlag,
 		RouteOverrides:       routeOverrides,
+		TCPRoutes:            tcpRoutes,
+		RouteServiceURL:      routeServiceURL,
 		NoRoutes:             noRoutesFlag,
 		Timeout:              timeoutFlag,
 	})
@@ -317,10 +558,16 @@ func (factory *DockerRunnerCommandFactory) createApp(context *cli.Context) {
 	go factory.tailedLogsOutputter.OutputTailedLogs(name)
 	defer factory.tailedLogsOutputter.StopOutputting()
 
-	ok := factory.pollUntilAllInstancesRunning(timeoutFlag, name, instancesFlag, "start")
+	ok := factory.pollUntilAllInstancesRunning(timeoutFlag, name, instancesFlag, "start", diagnosePlacementFromContext(context), monitorConfig.Method)
+	if ok && waitForFlag != "" {
+		ok = factory.waitForReady(waitForFlag, name, timeoutFlag)
+	}
 
 	if noRoutesFlag {
 		factory.ui.Say(colors.Green(name + " is now running.\n"))
+		if sshFlag {
+			factory.connectSSH(name, sshInstanceFlag, ok)
+		}
 		return
 	} else if ok {
 		factory.ui.Say(colors.Green(name + " is now running.\n"))
@@ -330,12 +577,345 @@ func (factory *DockerRunnerCommandFactory) createApp(context *cli.Context) {
 	}
 
 	if routeOverrides != nil {
-		for _, route := range strings.Split(routesFlag, ",") {
-			factory.ui.Say(colors.Green(factory.urlForApp(strings.Split(route, ":")[1])))
+		for _, route := range routeOverrides {
+			factory.ui.Say(colors.Green(factory.urlForApp(route.HostnamePrefix)))
 		}
 	} else {
 		factory.ui.Say(colors.Green(factory.urlForApp(name)))
 	}
+
+	for _, tcpRoute := range tcpRoutes {
+		factory.ui.Say(colors.Green(fmt.Sprintf("tcp://%s:%d -> container:%d\n", factory.domain, tcpRoute.ExternalPort, tcpRoute.ContainerPort)))
+	}
+
+	if routeServiceURL != "" {
+		factory.ui.Say(colors.Green(fmt.Sprintf("Routed through: %s\n", routeServiceURL)))
+	}
+
+	if sshFlag {
+		factory.connectSSH(name, sshInstanceFlag, ok)
+	}
+}
+
+// connectSSH hands off to the injected SSH connector once create has
+// confirmed the app is running, so --ssh lands a user in a shell without a
+// second command. It no-ops with a message instead of connecting into a
+// container that was never confirmed up.
+func (factory *DockerRunnerCommandFactory) connectSSH(appName string, instanceIndex int, appIsRunning bool) {
+	if !appIsRunning {
+		factory.ui.Say(colors.Red(fmt.Sprintf("Not dropping into a shell: %s never reached a running state.\n", appName)))
+		return
+	}
+
+	if factory.ssh == nil {
+		factory.ui.Say(colors.Red("--ssh requires an SSH connector, but none was configured.\n"))
+		return
+	}
+
+	if err := factory.ssh.ConnectToShell(appName, instanceIndex, ""); err != nil {
+		factory.ui.Say(colors.Red(fmt.Sprintf("Error connecting to %s/%d: %s\n", appName, instanceIndex, err)))
+	}
+}
+
+// createAppsFromManifest creates every app described by a --manifest file,
+// overriding manifest values field-by-field with any flags passed alongside
+// it. It continues past a single app's failure so the rest of the manifest
+// still gets a chance to create, and exits non-zero if any app failed.
+func (factory *DockerRunnerCommandFactory) createAppsFromManifest(context *cli.Context, manifestPath string) {
+	manifestBytes, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		factory.ui.Say(fmt.Sprintf("Error reading manifest: %s", err))
+		factory.exitHandler.Exit(exit_codes.FileSystemError)
+		return
+	}
+
+	parsedManifest, err := manifest.Parse(manifestBytes)
+	if err != nil {
+		factory.ui.Say(fmt.Sprintf("Error parsing manifest: %s", err))
+		factory.exitHandler.Exit(exit_codes.InvalidSyntax)
+		return
+	}
+
+	apps := parsedManifest.Apps()
+	if len(apps) > 1 && (context.Args().Get(0) != "" || context.Args().Get(1) != "") {
+		factory.ui.SayIncorrectUsage("APP_NAME and DOCKER_IMAGE cannot be passed alongside a --manifest that describes multiple services")
+		factory.exitHandler.Exit(exit_codes.InvalidSyntax)
+		return
+	}
+
+	anyFailed := false
+	for _, appSpec := range apps {
+		overriddenSpec, err := factory.applyManifestOverrides(context, appSpec)
+		if err == nil {
+			err = factory.createAppFromSpec(context, overriddenSpec)
+		}
+		if err != nil {
+			factory.ui.Say(colors.Red(fmt.Sprintf("%s: %s\n", appSpec.Name, err)))
+			anyFailed = true
+			continue
+		}
+		factory.ui.Say(colors.Green(fmt.Sprintf("%s: created\n", appSpec.Name)))
+	}
+
+	if anyFailed {
+		factory.exitHandler.Exit(exit_codes.CommandFailed)
+	}
+}
+
+// applyManifestOverrides merges CLI flags explicitly passed alongside
+// --manifest/--from-json on top of a single app's spec values, field-by-field.
+func (factory *DockerRunnerCommandFactory) applyManifestOverrides(context *cli.Context, appSpec manifest.AppSpec) (manifest.AppSpec, error) {
+	if context.IsSet("working-dir") {
+		appSpec.WorkingDir = context.String("working-dir")
+	}
+	if context.IsSet("instances") {
+		appSpec.Instances = context.Int("instances")
+	}
+	if context.IsSet("cpu-weight") {
+		appSpec.CPUWeight = uint(context.Int("cpu-weight"))
+	}
+	if context.IsSet("memory-mb") {
+		appSpec.MemoryMB = context.Int("memory-mb")
+	}
+	if context.IsSet("disk-mb") {
+		appSpec.DiskMB = context.Int("disk-mb")
+	}
+	if context.IsSet("user") {
+		appSpec.User = context.String("user")
+	}
+	if name := context.Args().Get(0); name != "" {
+		appSpec.Name = name
+	}
+	if image := context.Args().Get(1); image != "" {
+		appSpec.Image = image
+	}
+
+	if context.IsSet("ports") {
+		ports, err := parsePortsOverride(context.String("ports"))
+		if err != nil {
+			return appSpec, err
+		}
+		appSpec.Ports = ports
+	}
+
+	switch {
+	case context.IsSet("no-monitor"):
+		appSpec.Monitor = manifest.MonitorSpec{None: true}
+	case context.IsSet("monitor-command"):
+		appSpec.Monitor = manifest.MonitorSpec{Command: context.String("monitor-command")}
+	case context.IsSet("monitor-url"):
+		port, uri, err := parseURLMonitorFlag(context.String("monitor-url"))
+		if err != nil {
+			return appSpec, err
+		}
+		appSpec.Monitor = manifest.MonitorSpec{Port: port, URL: uri}
+	case context.IsSet("monitor-port"):
+		appSpec.Monitor = manifest.MonitorSpec{Port: uint16(context.Int("monitor-port"))}
+	}
+
+	if httpRoutes := context.StringSlice("http-route"); len(httpRoutes) > 0 {
+		appSpec.Routes = httpRoutes
+	} else if context.IsSet("routes") {
+		appSpec.Routes = strings.Split(context.String("routes"), ",")
+	}
+
+	for _, envVarPair := range context.StringSlice("env") {
+		name, value := parseEnvVarPair(envVarPair)
+		if appSpec.Env == nil {
+			appSpec.Env = map[string]string{}
+		}
+		appSpec.Env[name] = value
+	}
+
+	return appSpec, nil
+}
+
+// parsePortsOverride parses the comma-separated --ports flag the same way
+// the flag-driven create path does.
+func parsePortsOverride(portsFlag string) ([]uint16, error) {
+	var ports []uint16
+	for _, p := range strings.Split(portsFlag, ",") {
+		intPort, err := strconv.Atoi(p)
+		if err != nil || intPort > 65535 {
+			return nil, errors.New(InvalidPortErrorMessage)
+		}
+		ports = append(ports, uint16(intPort))
+	}
+	return ports, nil
+}
+
+// parseURLMonitorFlag parses the "port:/path" format accepted by --monitor-url.
+func parseURLMonitorFlag(urlMonitorFlag string) (uint16, string, error) {
+	urlMonitorArr := strings.Split(urlMonitorFlag, ":")
+	if len(urlMonitorArr) != 2 {
+		return 0, "", errors.New(InvalidPortErrorMessage)
+	}
+
+	port, err := strconv.Atoi(urlMonitorArr[0])
+	if err != nil {
+		return 0, "", errors.New(InvalidPortErrorMessage)
+	}
+
+	return uint16(port), urlMonitorArr[1], nil
+}
+
+// monitorConfigFromSpec resolves the monitor method a manifest/JSON spec
+// asked for, falling back to no monitoring when none of the monitor fields
+// are set.
+func monitorConfigFromSpec(monitor manifest.MonitorSpec) app_runner.MonitorConfig {
+	switch {
+	case monitor.Command != "":
+		return app_runner.MonitorConfig{Method: app_runner.CommandMonitor, Command: monitor.Command}
+	case monitor.URL != "":
+		return app_runner.MonitorConfig{Method: app_runner.URLMonitor, Port: monitor.Port, URI: monitor.URL}
+	case !monitor.None && monitor.Port != 0:
+		return app_runner.MonitorConfig{Method: app_runner.PortMonitor, Port: monitor.Port}
+	default:
+		return app_runner.MonitorConfig{Method: app_runner.NoMonitor}
+	}
+}
+
+// instancesFromSpec resolves the instance count a manifest/JSON spec asked
+// for, defaulting to 1 when the spec omits it.
+func instancesFromSpec(appSpec manifest.AppSpec) int {
+	if appSpec.Instances == 0 {
+		return 1
+	}
+	return appSpec.Instances
+}
+
+func (factory *DockerRunnerCommandFactory) createAppFromSpec(context *cli.Context, appSpec manifest.AppSpec) error {
+	if exists, err := factory.appExaminer.AppExists(appSpec.Name); err != nil {
+		return err
+	} else if exists {
+		return errors.New(AppAlreadyExistsErrorMessage)
+	}
+
+	imageMetadata, err := factory.dockerMetadataFetcher.FetchMetadata(appSpec.Image)
+	if err != nil {
+		return err
+	}
+
+	exposedPorts := appSpec.Ports
+	if len(exposedPorts) == 0 {
+		exposedPorts = imageMetadata.ExposedPorts
+	}
+
+	monitorConfig := monitorConfigFromSpec(appSpec.Monitor)
+
+	routeOverrides, err := parseRouteOverrides(strings.Join(appSpec.Routes, ","))
+	if err != nil {
+		return err
+	}
+
+	user := appSpec.User
+	if user == "" {
+		user = imageMetadata.User
+	}
+
+	environmentVariables, err := factory.buildEnvironment(mapToEnvSlice(appSpec.Env), nil, appSpec.Name, imageMetadata)
+	if err != nil {
+		return err
+	}
+
+	// A spec is allowed to omit any of these fields, falling back to the
+	// same defaults the flag-driven path uses.
+	instances := instancesFromSpec(appSpec)
+
+	cpuWeight := appSpec.CPUWeight
+	if cpuWeight == 0 {
+		cpuWeight = 100
+	}
+
+	memoryMB := appSpec.MemoryMB
+	if memoryMB == 0 {
+		memoryMB = 128
+	}
+
+	workingDir := appSpec.WorkingDir
+	if workingDir == "" {
+		workingDir = imageMetadata.WorkingDir
+	}
+	if workingDir == "" {
+		workingDir = "/"
+	}
+
+	return factory.dockerAppRunner.CreateDockerApp(app_runner.CreateAppParams{
+		Name:                 appSpec.Name,
+		RootFS:               appSpec.Image,
+		EnvironmentVariables: environmentVariables,
+		User:                 user,
+		Monitor:              monitorConfig,
+		Instances:            instances,
+		CPUWeight:            cpuWeight,
+		MemoryMB:             memoryMB,
+		DiskMB:               appSpec.DiskMB,
+		ExposedPorts:         exposedPorts,
+		WorkingDir:           workingDir,
+		RouteOverrides:       routeOverrides,
+	})
+}
+
+// createAppFromJSON implements `ltc create --from-json`: a declarative,
+// source-controllable alternative to the interactive flag-driven path that
+// still runs docker metadata fetch to fill in the exposed ports, working
+// directory, user and environment the spec omits.
+func (factory *DockerRunnerCommandFactory) createAppFromJSON(context *cli.Context, path string, dryRun bool) {
+	specBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		factory.ui.Say(fmt.Sprintf("Error reading spec: %s", err))
+		factory.exitHandler.Exit(exit_codes.FileSystemError)
+		return
+	}
+
+	jsonSpec, err := manifest.ParseJSON(specBytes)
+	if err != nil {
+		factory.ui.Say(fmt.Sprintf("Error parsing spec: %s", err))
+		factory.exitHandler.Exit(exit_codes.InvalidSyntax)
+		return
+	}
+
+	if jsonSpec.SchemaVersion != manifest.CurrentSchemaVersion {
+		factory.ui.Say(UnsupportedSchemaVersionErrorMessage)
+		factory.exitHandler.Exit(exit_codes.InvalidSyntax)
+		return
+	}
+
+	appSpec, err := factory.applyManifestOverrides(context, jsonSpec.AppSpec)
+	if err != nil {
+		factory.ui.Say(err.Error())
+		factory.exitHandler.Exit(exit_codes.InvalidSyntax)
+		return
+	}
+
+	if dryRun {
+		factory.ui.Say(fmt.Sprintf("%+v\n", appSpec))
+		return
+	}
+
+	if err := factory.createAppFromSpec(context, appSpec); err != nil {
+		factory.ui.Say(fmt.Sprintf("Error creating app: %s", err))
+		factory.exitHandler.Exit(exit_codes.CommandFailed)
+		return
+	}
+
+	factory.ui.Say("Creating App: " + appSpec.Name + "\n")
+
+	go factory.tailedLogsOutputter.OutputTailedLogs(appSpec.Name)
+	defer factory.tailedLogsOutputter.StopOutputting()
+
+	monitorConfig := monitorConfigFromSpec(appSpec.Monitor)
+	if factory.pollUntilAllInstancesRunning(context.Duration("timeout"), appSpec.Name, instancesFromSpec(appSpec), pollingStart, diagnosePlacementFromContext(context), monitorConfig.Method) {
+		factory.ui.Say(colors.Green(appSpec.Name + " is now running.\n"))
+	}
+}
+
+func mapToEnvSlice(env map[string]string) []string {
+	envSlice := make([]string, 0, len(env))
+	for name, value := range env {
+		envSlice = append(envSlice, name+"="+value)
+	}
+	return envSlice
 }
 
 func (factory *DockerRunnerCommandFactory) pollUntilSuccess(pollTimeout time.Duration, pollingFunc func() bool, outputProgress bool) (ok bool) {
@@ -354,12 +934,16 @@ func (factory *DockerRunnerCommandFactory) pollUntilSuccess(pollTimeout time.Dur
 	return false
 }
 
-func (factory *DockerRunnerCommandFactory) pollUntilAllInstancesRunning(pollTimeout time.Duration, appName string, instances int, action pollingAction) bool {
+func (factory *DockerRunnerCommandFactory) pollUntilAllInstancesRunning(pollTimeout time.Duration, appName string, instances int, action pollingAction, diagnosePlacement bool, monitorMethod app_runner.MonitorMethod) bool {
 	placementErrorOccurred := false
 	ok := factory.pollUntilSuccess(pollTimeout, func() bool {
 		numberOfRunningInstances, placementError, _ := factory.appExaminer.RunningAppInstancesInfo(appName)
 		if placementError {
 			factory.ui.Say(colors.Red("Error, could not place all instances: insufficient resources. Try requesting fewer instances or reducing the requested memory or disk capacity."))
+			if diagnosePlacement {
+				factory.ui.SayNewLine()
+				factory.renderCellDistribution()
+			}
 			placementErrorOccurred = true
 			return true
 		}
@@ -373,8 +957,13 @@ func (factory *DockerRunnerCommandFactory) pollUntilAllInstancesRunning(pollTime
 		if action == pollingStart {
 			factory.ui.Say(colors.Red("Timed out waiting for the container to come up."))
 			factory.ui.SayNewLine()
-			factory.ui.SayLine("This typically happens because docker layers can take time to download.")
-			factory.ui.SayLine("Lattice is still downloading your application in the background.")
+			if monitorMethod == app_runner.CommandMonitor {
+				factory.ui.SayLine("This can happen because docker layers can take time to download, or because --monitor-command is exiting non-zero.")
+				factory.ui.SayLine("Lattice is still downloading your application in the background.")
+			} else {
+				factory.ui.SayLine("This typically happens because docker layers can take time to download.")
+				factory.ui.SayLine("Lattice is still downloading your application in the background.")
+			}
 		} else {
 			factory.ui.Say(colors.Red("Timed out waiting for the container to scale."))
 			factory.ui.SayNewLine()
@@ -387,24 +976,186 @@ func (factory *DockerRunnerCommandFactory) pollUntilAllInstancesRunning(pollTime
 	return ok
 }
 
+// renderCellDistribution prints each cell's running (green) and claimed
+// (yellow) instance counts, flagging cells the BBS hasn't heard from as
+// [MISSING] and cells with no instances at all as empty, so a placement
+// error is immediately actionable.
+func (factory *DockerRunnerCommandFactory) renderCellDistribution() {
+	cells, err := factory.appExaminer.ListCells()
+	if err != nil {
+		factory.ui.Say(colors.Red(fmt.Sprintf("Error fetching cell distribution: %s\n", err)))
+		return
+	}
+
+	for _, cell := range cells {
+		if cell.Missing {
+			factory.ui.Say(colors.Red(fmt.Sprintf("%s [MISSING]\n", cell.CellID)))
+			continue
+		}
+
+		if cell.RunningInstances == 0 && cell.ClaimedInstances == 0 {
+			factory.ui.Say(fmt.Sprintf("%s: %s\n", cell.CellID, colors.Red("empty")))
+			continue
+		}
+
+		bar := strings.Repeat(colors.Green("●"), cell.RunningInstances) + strings.Repeat(colors.Yellow("●"), cell.ClaimedInstances)
+		factory.ui.Say(fmt.Sprintf("%s: %s\n", cell.CellID, bar))
+	}
+}
+
+// waitForReady is consulted once pollUntilAllInstancesRunning reports the
+// target instance count, since a container the backend calls "running" is
+// not necessarily serving traffic yet.
+func (factory *DockerRunnerCommandFactory) waitForReady(waitForFlag, appName string, pollTimeout time.Duration) bool {
+	kind, target, err := parseWaitFor(waitForFlag)
+	if err != nil {
+		factory.ui.Say(err.Error())
+		return false
+	}
+
+	if kind == "tcp" {
+		return factory.waitForTCP(target, appName, pollTimeout)
+	}
+	return factory.waitForHTTP(target, appName, pollTimeout)
+}
+
+func parseWaitFor(waitForFlag string) (kind, target string, err error) {
+	parts := strings.SplitN(waitForFlag, ":", 2)
+	if len(parts) != 2 || (parts[0] != "http" && parts[0] != "tcp") {
+		return "", "", errors.New(MalformedWaitForErrorMessage)
+	}
+	return parts[0], parts[1], nil
+}
+
+// validateRouteServiceURL requires an absolute https:// URL, mirroring the
+// route-service binding rules enforced by the CF routing API.
+func validateRouteServiceURL(routeServiceURL string) error {
+	parsed, err := url.Parse(routeServiceURL)
+	if err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+		return errors.New(InvalidRouteServiceURLErrorMessage)
+	}
+	return nil
+}
+
+// diagnosePlacementFromContext resolves --diagnose-placement, defaulting to
+// on for interactive terminals and off otherwise so scripted output stays
+// unchanged unless the user opts in or out explicitly.
+func diagnosePlacementFromContext(context *cli.Context) bool {
+	if context.IsSet("diagnose-placement") {
+		return context.Bool("diagnose-placement")
+	}
+	return terminal.IsTTY()
+}
+
+func (factory *DockerRunnerCommandFactory) waitForHTTP(path, appName string, pollTimeout time.Duration) bool {
+	url := fmt.Sprintf("http://%s.%s/%s", appName, factory.domain, strings.TrimPrefix(path, "/"))
+
+	var lastStatus, lastBody string
+	ok := factory.pollWithBackoff(pollTimeout, func() bool {
+		resp, err := factory.httpClient.Get(url)
+		if err != nil {
+			lastStatus = err.Error()
+			return false
+		}
+		defer resp.Body.Close()
+
+		body, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return true
+		}
+
+		lastStatus = resp.Status
+		lastBody = string(body)
+		return false
+	})
+
+	if !ok {
+		factory.ui.Say(colors.Red(fmt.Sprintf("Timed out waiting for %s to respond. Last response: %s %s\n", url, lastStatus, lastBody)))
+	}
+	return ok
+}
+
+func (factory *DockerRunnerCommandFactory) waitForTCP(port, appName string, pollTimeout time.Duration) bool {
+	address := fmt.Sprintf("%s.%s:%s", appName, factory.domain, port)
+
+	ok := factory.pollWithBackoff(pollTimeout, func() bool {
+		conn, err := net.DialTimeout("tcp", address, waitForMinBackoff)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	})
+
+	if !ok {
+		factory.ui.Say(colors.Red(fmt.Sprintf("Timed out waiting for %s to accept connections.\n", address)))
+	}
+	return ok
+}
+
+// pollWithBackoff retries pollingFunc with exponential backoff (250ms,
+// capped at 5s) until it succeeds or pollTimeout elapses.
+func (factory *DockerRunnerCommandFactory) pollWithBackoff(pollTimeout time.Duration, pollingFunc func() bool) bool {
+	startingTime := factory.clock.Now()
+	backoff := waitForMinBackoff
+	for startingTime.Add(pollTimeout).After(factory.clock.Now()) {
+		if pollingFunc() {
+			return true
+		}
+
+		factory.clock.Sleep(backoff)
+		if backoff *= 2; backoff > waitForMaxBackoff {
+			backoff = waitForMaxBackoff
+		}
+	}
+	return false
+}
+
 func (factory *DockerRunnerCommandFactory) urlForApp(name string) string {
 	return fmt.Sprintf("http://%s.%s\n", name, factory.domain)
 }
 
-func (factory *DockerRunnerCommandFactory) buildEnvironment(envVars []string, appName string) map[string]string {
+// buildEnvironment assembles the environment for a new app. Precedence, low
+// to high: the image's declared environment < PROCESS_GUID (defaulted here,
+// but explicitly overridable) < --env-file (in the order given) < -e/--env.
+func (factory *DockerRunnerCommandFactory) buildEnvironment(envVars, envFiles []string, appName string, imageMetadata *docker_metadata_fetcher.ImageMetadata) (map[string]string, error) {
 	environment := make(map[string]string)
+
+	for _, envVarPair := range imageMetadata.Env {
+		name, value := parseEnvVarPair(envVarPair)
+		environment[name] = value
+	}
+
 	environment["PROCESS_GUID"] = appName
 
+	for _, envFilePath := range envFiles {
+		fileVars, err := parseEnvFile(envFilePath)
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range fileVars {
+			environment[name] = value
+		}
+	}
+
 	for _, envVarPair := range envVars {
 		name, value := parseEnvVarPair(envVarPair)
 
-		if value == "" {
+		switch {
+		case strings.HasPrefix(value, "@"):
+			fileContents, err := ioutil.ReadFile(strings.TrimPrefix(value, "@"))
+			if err != nil {
+				return nil, fmt.Errorf("Error reading %s: %s", envVarPair, err)
+			}
+			value = string(fileContents)
+		case value == "":
 			value = factory.grabVarFromEnv(name)
 		}
 
 		environment[name] = value
 	}
-	return environment
+
+	return environment, nil
 }
 
 func (factory *DockerRunnerCommandFactory) grabVarFromEnv(name string) string {
@@ -417,6 +1168,75 @@ func (factory *DockerRunnerCommandFactory) grabVarFromEnv(name string) string {
 	return ""
 }
 
+// parseEnvFile reads Docker-style env-file lines (KEY=VALUE, blank lines and
+// #-comments skipped), erroring out with the offending line number so a typo
+// is easy to find.
+func parseEnvFile(path string) (map[string]string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading --env-file %s: %s", path, err)
+	}
+
+	envVars := map[string]string{}
+	for i, line := range strings.Split(string(contents), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if !strings.Contains(trimmed, "=") {
+			return nil, fmt.Errorf("Malformed --env-file %s at line %d: expected KEY=VALUE", path, i+1)
+		}
+
+		name, value := parseEnvVarPair(trimmed)
+		envVars[name] = value
+	}
+	return envVars, nil
+}
+
+func (factory *DockerRunnerCommandFactory) resolvePinnedRootFS(dockerImage, pullPolicyFlag, imageDigestFlag string, imageMetadata *docker_metadata_fetcher.ImageMetadata) (string, error) {
+	if pullPolicyFlag == "missing" && imageDigestFlag != "" {
+		return dockerImageWithDigest(dockerImage, imageDigestFlag), nil
+	}
+
+	digest := imageMetadata.Digest
+
+	switch pullPolicyFlag {
+	case "never":
+		if digest == "" {
+			return "", errors.New(NoCachedDigestErrorMessage)
+		}
+	case "always":
+		if imageDigestFlag != "" && digest != "" && imageDigestFlag != digest {
+			return "", errors.New(DigestMismatchErrorMessage)
+		}
+		if imageDigestFlag != "" {
+			digest = imageDigestFlag
+		}
+	}
+
+	if digest == "" {
+		return dockerImage, nil
+	}
+
+	return dockerImageWithDigest(dockerImage, digest), nil
+}
+
+func dockerImageWithDigest(dockerImage, digest string) string {
+	repo := strings.SplitN(dockerImage, ":", 2)[0]
+	return fmt.Sprintf("docker:///%s@%s", repo, digest)
+}
+
+// parseRepoTag splits a "repo:tag" docker image reference, defaulting the
+// tag to "latest" per docker convention when none is given.
+func parseRepoTag(dockerImage string) (repo, tag string) {
+	parts := strings.SplitN(dockerImage, ":", 2)
+	if len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return parts[0], "latest"
+}
+
 func (factory *DockerRunnerCommandFactory) getExposedPortsFromArgs(portsFlag string, imageMetadata *docker_metadata_fetcher.ImageMetadata) ([]uint16, error) {
 	if portsFlag != "" {
 		portStrings := strings.Split(portsFlag, ",")
@@ -446,32 +1266,39 @@ func (factory *DockerRunnerCommandFactory) getExposedPortsFromArgs(portsFlag str
 	return []uint16{8080}, nil
 }
 
-func (factory *DockerRunnerCommandFactory) getMonitorConfigFromArgs(exposedPorts []uint16, portMonitorFlag int, noMonitorFlag bool, urlMonitorFlag string, monitorTimeoutFlag time.Duration, imageMetadata *docker_metadata_fetcher.ImageMetadata) (app_runner.MonitorConfig, error) {
+func (factory *DockerRunnerCommandFactory) getMonitorConfigFromArgs(exposedPorts []uint16, portMonitorFlag int, noMonitorFlag bool, urlMonitorFlag, commandMonitorFlag string, monitorTimeoutFlag time.Duration, imageMetadata *docker_metadata_fetcher.ImageMetadata) (app_runner.MonitorConfig, error) {
 	if noMonitorFlag {
 		return app_runner.MonitorConfig{
 			Method: app_runner.NoMonitor,
 		}, nil
 	}
 
-	if urlMonitorFlag != "" {
-		urlMonitorArr := strings.Split(urlMonitorFlag, ":")
-		if len(urlMonitorArr) != 2 {
-			return app_runner.MonitorConfig{}, errors.New(InvalidPortErrorMessage)
+	if commandMonitorFlag != "" {
+		if strings.TrimSpace(commandMonitorFlag) == "" {
+			return app_runner.MonitorConfig{}, errors.New(MonitorCommandEmptyErrorMessage)
 		}
 
-		urlMonitorPort, err := strconv.Atoi(urlMonitorArr[0])
+		return app_runner.MonitorConfig{
+			Method:  app_runner.CommandMonitor,
+			Command: commandMonitorFlag,
+			Timeout: monitorTimeoutFlag,
+		}, nil
+	}
+
+	if urlMonitorFlag != "" {
+		urlMonitorPort, uri, err := parseURLMonitorFlag(urlMonitorFlag)
 		if err != nil {
-			return app_runner.MonitorConfig{}, errors.New(InvalidPortErrorMessage)
+			return app_runner.MonitorConfig{}, err
 		}
 
-		if err := checkPortExposed(exposedPorts, uint16(urlMonitorPort)); err != nil {
+		if err := checkPortExposed(exposedPorts, urlMonitorPort); err != nil {
 			return app_runner.MonitorConfig{}, err
 		}
 
 		return app_runner.MonitorConfig{
 			Method:  app_runner.URLMonitor,
-			Port:    uint16(urlMonitorPort),
-			URI:     urlMonitorArr[1],
+			Port:    urlMonitorPort,
+			URI:     uri,
 			Timeout: monitorTimeoutFlag,
 		}, nil
 	}
@@ -536,6 +1363,61 @@ func parseRouteOverrides(routes string) (app_runner.RouteOverrides, error) {
 	return routeOverrides, nil
 }
 
+func parseHTTPRouteOverrides(httpRoutes []string) (app_runner.RouteOverrides, error) {
+	var routeOverrides app_runner.RouteOverrides
+
+	for _, route := range httpRoutes {
+		routeArr := strings.Split(route, ":")
+		maybePort, err := strconv.Atoi(routeArr[0])
+		if err != nil || len(routeArr) < 2 {
+			return nil, errors.New(MalformedRouteErrorMessage)
+		}
+
+		routeOverrides = append(routeOverrides, app_runner.RouteOverride{HostnamePrefix: routeArr[1], Port: uint16(maybePort)})
+	}
+
+	return routeOverrides, nil
+}
+
+// reservedHTTPRouterPorts are the well-known ports the shared HTTP router
+// listens on; a --tcp-route can never claim them as its external port.
+var reservedHTTPRouterPorts = map[uint16]bool{80: true, 443: true}
+
+func parseTCPRouteOverrides(tcpRoutes []string, exposedPorts []uint16) ([]route_helpers.TCPRoute, error) {
+	var tcpRouteOverrides []route_helpers.TCPRoute
+	seenExternalPorts := map[uint16]bool{}
+
+	for _, route := range tcpRoutes {
+		routeArr := strings.Split(route, ":")
+		if len(routeArr) != 2 {
+			return nil, errors.New(MalformedTCPRouteErrorMessage)
+		}
+
+		externalPort, err := strconv.Atoi(routeArr[0])
+		if err != nil {
+			return nil, errors.New(MalformedTCPRouteErrorMessage)
+		}
+
+		containerPort, err := strconv.Atoi(routeArr[1])
+		if err != nil {
+			return nil, errors.New(MalformedTCPRouteErrorMessage)
+		}
+
+		if err := checkPortExposed(exposedPorts, uint16(containerPort)); err != nil {
+			return nil, errors.New(TCPRoutePortNotExposed)
+		}
+
+		if reservedHTTPRouterPorts[uint16(externalPort)] || seenExternalPorts[uint16(externalPort)] {
+			return nil, errors.New(TCPRouteExternalPortCollisionErrorMessage)
+		}
+		seenExternalPorts[uint16(externalPort)] = true
+
+		tcpRouteOverrides = append(tcpRouteOverrides, route_helpers.TCPRoute{ExternalPort: uint16(externalPort), ContainerPort: uint16(containerPort)})
+	}
+
+	return tcpRouteOverrides, nil
+}
+
 func parseEnvVarPair(envVarPair string) (name, value string) {
 	s := strings.SplitN(envVarPair, "=", 2)
 	if len(s) > 1 {
@@ -1,13 +1,18 @@
 package command_factory_test
 
 import (
+	"bytes"
 	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
 	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/gbytes"
 
+	"github.com/cloudfoundry-incubator/lattice/ltc/app_examiner"
 	"github.com/cloudfoundry-incubator/lattice/ltc/app_examiner/fake_app_examiner"
 	"github.com/cloudfoundry-incubator/lattice/ltc/app_runner"
 	"github.com/cloudfoundry-incubator/lattice/ltc/app_runner/docker_metadata_fetcher"
@@ -18,6 +23,7 @@ import (
 	"github.com/cloudfoundry-incubator/lattice/ltc/exit_handler/exit_codes"
 	"github.com/cloudfoundry-incubator/lattice/ltc/exit_handler/fake_exit_handler"
 	"github.com/cloudfoundry-incubator/lattice/ltc/logs/console_tailed_logs_outputter/fake_tailed_logs_outputter"
+	"github.com/cloudfoundry-incubator/lattice/ltc/route_helpers"
 	"github.com/cloudfoundry-incubator/lattice/ltc/terminal"
 	"github.com/cloudfoundry-incubator/lattice/ltc/terminal/colors"
 	"github.com/cloudfoundry-incubator/lattice/ltc/test_helpers"
@@ -27,6 +33,28 @@ import (
 	"github.com/pivotal-golang/lager"
 )
 
+type stubHTTPClient struct {
+	GetStub func(url string) (*http.Response, error)
+}
+
+func (client *stubHTTPClient) Get(url string) (*http.Response, error) {
+	return client.GetStub(url)
+}
+
+type stubSSHConnector struct {
+	connectAppName      string
+	connectInstanceIndex int
+	connectCallCount    int
+	ConnectReturns      error
+}
+
+func (ssh *stubSSHConnector) ConnectToShell(appName string, instanceIndex int, command string) error {
+	ssh.connectCallCount++
+	ssh.connectAppName = appName
+	ssh.connectInstanceIndex = instanceIndex
+	return ssh.ConnectReturns
+}
+
 var _ = Describe("CommandFactory", func() {
 
 	var (
@@ -42,6 +70,8 @@ var _ = Describe("CommandFactory", func() {
 		logger                        lager.Logger
 		fakeTailedLogsOutputter       *fake_tailed_logs_outputter.FakeTailedLogsOutputter
 		fakeExitHandler               *fake_exit_handler.FakeExitHandler
+		fakeHTTPClient                *stubHTTPClient
+		fakeSSHConnector              *stubSSHConnector
 	)
 
 	BeforeEach(func() {
@@ -55,6 +85,8 @@ var _ = Describe("CommandFactory", func() {
 		logger = lager.NewLogger("ltc-test")
 		fakeTailedLogsOutputter = fake_tailed_logs_outputter.NewFakeTailedLogsOutputter()
 		fakeExitHandler = &fake_exit_handler.FakeExitHandler{}
+		fakeHTTPClient = &stubHTTPClient{}
+		fakeSSHConnector = &stubSSHConnector{}
 	})
 
 	Describe("CreateAppCommand", func() {
@@ -74,6 +106,8 @@ var _ = Describe("CommandFactory", func() {
 				Logger:                logger,
 				TailedLogsOutputter:   fakeTailedLogsOutputter,
 				ExitHandler:           fakeExitHandler,
+				HTTPClient:            fakeHTTPClient,
+				SSH:                   fakeSSHConnector,
 			}
 
 			commandFactory := command_factory.NewDockerRunnerCommandFactory(appRunnerCommandFactoryConfig)
@@ -89,7 +123,7 @@ var _ = Describe("CommandFactory", func() {
 				"--disk-mb=12",
 				"--routes=3000:route-3000-yay,1111:route-1111-wahoo,1111:route-1111-me-too",
 				"--working-dir=/applications",
-				"--run-as-root=true",
+				"--privileged",
 				"--instances=22",
 				"--env=TIMEZONE=CST",
 				`--env=LANG="Chicago English"`,
@@ -169,6 +203,91 @@ var _ = Describe("CommandFactory", func() {
 			})
 		})
 
+		Describe("--env-file and richer --env semantics", func() {
+			var envFilePath string
+
+			BeforeEach(func() {
+				appExaminer.RunningAppInstancesInfoReturns(1, false, nil)
+
+				tmpFile, err := ioutil.TempFile("", "lattice-env-file")
+				Expect(err).NotTo(HaveOccurred())
+				envFilePath = tmpFile.Name()
+				tmpFile.Close()
+			})
+
+			AfterEach(func() {
+				os.Remove(envFilePath)
+			})
+
+			It("loads KEY=VALUE pairs from --env-file, skipping blanks and comments", func() {
+				Expect(ioutil.WriteFile(envFilePath, []byte("\n# a comment\nFOO=BAR\nBAZ=WIBBLE\n"), 0644)).To(Succeed())
+
+				args := []string{"cool-web-app", "superfun/app", "--env-file=" + envFilePath, "--", "/start-me-please"}
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				appEnvVars := dockerRunner.CreateDockerAppArgsForCall(0).EnvironmentVariables
+				Expect(appEnvVars["FOO"]).To(Equal("BAR"))
+				Expect(appEnvVars["BAZ"]).To(Equal("WIBBLE"))
+			})
+
+			It("lets -e override a value loaded from --env-file", func() {
+				Expect(ioutil.WriteFile(envFilePath, []byte("FOO=BAR\n"), 0644)).To(Succeed())
+
+				args := []string{"cool-web-app", "superfun/app", "--env-file=" + envFilePath, "--env=FOO=OVERRIDDEN", "--", "/start-me-please"}
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				appEnvVars := dockerRunner.CreateDockerAppArgsForCall(0).EnvironmentVariables
+				Expect(appEnvVars["FOO"]).To(Equal("OVERRIDDEN"))
+			})
+
+			It("starts from the image's declared environment, overridable by --env-file and -e", func() {
+				dockerMetadataFetcher.FetchMetadataReturns(&docker_metadata_fetcher.ImageMetadata{
+					Env: []string{"FOO=from-image", "UNTOUCHED=from-image"},
+				}, nil)
+				Expect(ioutil.WriteFile(envFilePath, []byte("FOO=from-env-file\n"), 0644)).To(Succeed())
+
+				args := []string{"cool-web-app", "superfun/app", "--env-file=" + envFilePath, "--env=FOO=from-flag", "--", "/start-me-please"}
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				appEnvVars := dockerRunner.CreateDockerAppArgsForCall(0).EnvironmentVariables
+				Expect(appEnvVars["FOO"]).To(Equal("from-flag"))
+				Expect(appEnvVars["UNTOUCHED"]).To(Equal("from-image"))
+			})
+
+			It("inlines a file's contents for -e KEY=@/path/to/file", func() {
+				secretPath := envFilePath + "-secret"
+				Expect(ioutil.WriteFile(secretPath, []byte("super-secret-value"), 0644)).To(Succeed())
+				defer os.Remove(secretPath)
+
+				args := []string{"cool-web-app", "superfun/app", "--env=CERT=@" + secretPath, "--", "/start-me-please"}
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				appEnvVars := dockerRunner.CreateDockerAppArgsForCall(0).EnvironmentVariables
+				Expect(appEnvVars["CERT"]).To(Equal("super-secret-value"))
+			})
+
+			It("exits with InvalidSyntax before creating the app when --env-file is missing", func() {
+				args := []string{"cool-web-app", "superfun/app", "--env-file=/no/such/file", "--", "/start-me-please"}
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				Expect(dockerRunner.CreateDockerAppCallCount()).To(Equal(0))
+				Expect(outputBuffer).To(test_helpers.Say("Error reading --env-file"))
+				Expect(fakeExitHandler.ExitCalledWith).To(Equal([]int{exit_codes.InvalidSyntax}))
+			})
+
+			It("exits with InvalidSyntax and the line number when --env-file has a malformed line", func() {
+				Expect(ioutil.WriteFile(envFilePath, []byte("FOO=BAR\nNOT-KEY-VALUE\n"), 0644)).To(Succeed())
+
+				args := []string{"cool-web-app", "superfun/app", "--env-file=" + envFilePath, "--", "/start-me-please"}
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				Expect(dockerRunner.CreateDockerAppCallCount()).To(Equal(0))
+				Expect(outputBuffer).To(test_helpers.Say("Malformed --env-file"))
+				Expect(outputBuffer).To(test_helpers.Say("line 2"))
+				Expect(fakeExitHandler.ExitCalledWith).To(Equal([]int{exit_codes.InvalidSyntax}))
+			})
+		})
+
 		Context("when a malformed routes flag is passed", func() {
 			It("errors out when the port is not an int", func() {
 				args := []string{
@@ -530,6 +649,43 @@ var _ = Describe("CommandFactory", func() {
 				})
 			})
 
+			Context("when --monitor-command is passed", func() {
+				It("command-monitors the app", func() {
+					args := []string{
+						"cool-web-app",
+						"superfun/app",
+						`--monitor-command=/app/bin/healthcheck --verbose`,
+						"--",
+						"/start-me-please",
+					}
+
+					test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+					Expect(dockerRunner.CreateDockerAppCallCount()).To(Equal(1))
+					monitorConfig := dockerRunner.CreateDockerAppArgsForCall(0).Monitor
+					Expect(monitorConfig.Method).To(Equal(app_runner.CommandMonitor))
+					Expect(monitorConfig.Command).To(Equal("/app/bin/healthcheck --verbose"))
+
+					Expect(outputBuffer).To(test_helpers.Say("Monitoring the app with the command /app/bin/healthcheck --verbose...\n"))
+				})
+
+				It("errors out when the command is empty after trimming", func() {
+					args := []string{
+						"cool-web-app",
+						"superfun/app",
+						"--monitor-command=   ",
+						"--",
+						"/start-me-please",
+					}
+
+					test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+					Expect(dockerRunner.CreateDockerAppCallCount()).To(Equal(0))
+					Expect(outputBuffer).To(test_helpers.Say(command_factory.MonitorCommandEmptyErrorMessage))
+					Expect(fakeExitHandler.ExitCalledWith).To(Equal([]int{exit_codes.InvalidSyntax}))
+				})
+			})
+
 			Context("when multiple monitoring options are passed", func() {
 				It("no-monitor takes precedence", func() {
 					args := []string{
@@ -549,6 +705,25 @@ var _ = Describe("CommandFactory", func() {
 					Expect(monitorConfig.Method).To(Equal(app_runner.NoMonitor))
 				})
 
+				It("monitor-command takes precedence over monitor-url and monitor-port", func() {
+					args := []string{
+						"--ports=1200",
+						"--monitor-url=1200:/sup/yeah",
+						"--monitor-port=1200",
+						"--monitor-command=/bin/check",
+						"cool-web-app",
+						"superfun/app",
+						"--",
+						"/start-me-please",
+					}
+
+					test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+					Expect(dockerRunner.CreateDockerAppCallCount()).To(Equal(1))
+					monitorConfig := dockerRunner.CreateDockerAppArgsForCall(0).Monitor
+					Expect(monitorConfig.Method).To(Equal(app_runner.CommandMonitor))
+				})
+
 				It("monitor-url takes precedence over monitor-port", func() {
 					args := []string{
 						"--ports=1200",
@@ -593,6 +768,530 @@ var _ = Describe("CommandFactory", func() {
 			})
 		})
 
+		Describe("--manifest", func() {
+			var manifestPath string
+
+			BeforeEach(func() {
+				tmpFile, err := ioutil.TempFile("", "lattice-manifest")
+				Expect(err).NotTo(HaveOccurred())
+				manifestPath = tmpFile.Name()
+				tmpFile.Close()
+			})
+
+			AfterEach(func() {
+				os.Remove(manifestPath)
+			})
+
+			It("creates every app described under services:", func() {
+				Expect(ioutil.WriteFile(manifestPath, []byte(`
+services:
+  web:
+    image: superfun/web:latest
+    instances: 2
+  worker:
+    image: superfun/worker:latest
+    instances: 1
+`), 0644)).To(Succeed())
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, []string{"--manifest=" + manifestPath})
+
+				Expect(dockerRunner.CreateDockerAppCallCount()).To(Equal(2))
+				Expect(outputBuffer).To(test_helpers.Say("web: created"))
+				Expect(outputBuffer).To(test_helpers.Say("worker: created"))
+			})
+
+			It("continues past a failing app and exits non-zero", func() {
+				Expect(ioutil.WriteFile(manifestPath, []byte(`
+services:
+  web:
+    image: superfun/web:latest
+  worker:
+    image: superfun/worker:latest
+`), 0644)).To(Succeed())
+				dockerRunner.CreateDockerAppReturns(errors.New("Major Fault"))
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, []string{"--manifest=" + manifestPath})
+
+				Expect(dockerRunner.CreateDockerAppCallCount()).To(Equal(2))
+				Expect(fakeExitHandler.ExitCalledWith).To(Equal([]int{exit_codes.CommandFailed}))
+			})
+
+			It("rejects a manifest app name that already exists", func() {
+				Expect(ioutil.WriteFile(manifestPath, []byte(`
+image: superfun/app:latest
+`), 0644)).To(Succeed())
+				appExaminer.AppExistsReturns(true, nil)
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, []string{"app-to-start", "--manifest=" + manifestPath})
+
+				Expect(dockerRunner.CreateDockerAppCallCount()).To(Equal(0))
+				Expect(outputBuffer).To(test_helpers.Say(command_factory.AppAlreadyExistsErrorMessage))
+				Expect(fakeExitHandler.ExitCalledWith).To(Equal([]int{exit_codes.CommandFailed}))
+			})
+
+			It("rejects APP_NAME/DOCKER_IMAGE alongside a multi-service manifest", func() {
+				Expect(ioutil.WriteFile(manifestPath, []byte(`
+services:
+  web:
+    image: superfun/web:latest
+  worker:
+    image: superfun/worker:latest
+`), 0644)).To(Succeed())
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, []string{"cool-web-app", "superfun/app", "--manifest=" + manifestPath})
+
+				Expect(dockerRunner.CreateDockerAppCallCount()).To(Equal(0))
+				Expect(fakeExitHandler.ExitCalledWith).To(Equal([]int{exit_codes.InvalidSyntax}))
+			})
+
+			It("overrides ports, monitor, env and routes flags on top of the manifest", func() {
+				Expect(ioutil.WriteFile(manifestPath, []byte(`
+image: superfun/app:latest
+ports:
+- 9090
+env:
+  FOO: manifest-value
+routes:
+- 9090:manifest-host
+`), 0644)).To(Succeed())
+				appExaminer.RunningAppInstancesInfoReturns(1, false, nil)
+				args := []string{
+					"--manifest=" + manifestPath,
+					"--ports=8080",
+					"--no-monitor",
+					"-e", "FOO=flag-value",
+					"--http-route=8080:flag-host",
+				}
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				createDockerAppParameters := dockerRunner.CreateDockerAppArgsForCall(0)
+				Expect(createDockerAppParameters.ExposedPorts).To(Equal([]uint16{8080}))
+				Expect(createDockerAppParameters.Monitor.Method).To(Equal(app_runner.NoMonitor))
+				Expect(createDockerAppParameters.EnvironmentVariables["FOO"]).To(Equal("flag-value"))
+				Expect(createDockerAppParameters.RouteOverrides).To(Equal(app_runner.RouteOverrides{
+					{HostnamePrefix: "flag-host", Port: 8080},
+				}))
+			})
+		})
+
+		Describe("--wait-for", func() {
+			It("declares the app running once the http probe returns 2xx", func() {
+				fakeHTTPClient.GetStub = func(url string) (*http.Response, error) {
+					Expect(url).To(Equal("http://cool-web-app.192.168.11.11.xip.io/healthz"))
+					return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(bytes.NewBufferString(""))}, nil
+				}
+				args := []string{"cool-web-app", "superfun/app", "--wait-for=http:/healthz", "--", "/start-me-please"}
+				appExaminer.RunningAppInstancesInfoReturns(1, false, nil)
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				Expect(outputBuffer).To(test_helpers.Say(colors.Green("cool-web-app is now running.\n")))
+			})
+
+			It("retries with backoff and surfaces the last non-2xx response on timeout", func() {
+				fakeHTTPClient.GetStub = func(url string) (*http.Response, error) {
+					return &http.Response{StatusCode: 503, Status: "503 Service Unavailable", Body: ioutil.NopCloser(bytes.NewBufferString("nope"))}, nil
+				}
+				args := []string{"cool-web-app", "superfun/app", "--wait-for=http:/healthz", "--", "/start-me-please"}
+				appExaminer.RunningAppInstancesInfoReturns(1, false, nil)
+
+				commandFinishChan := test_helpers.AsyncExecuteCommandWithArgs(createCommand, args)
+				Eventually(outputBuffer).Should(test_helpers.Say("Creating App: cool-web-app"))
+
+				clock.IncrementBySeconds(125)
+
+				Eventually(commandFinishChan).Should(BeClosed())
+				Expect(outputBuffer).To(test_helpers.Say(colors.Red("Timed out waiting for http://cool-web-app.192.168.11.11.xip.io/healthz to respond. Last response: 503 Service Unavailable nope\n")))
+				Expect(outputBuffer).ToNot(test_helpers.Say("is now running"))
+			})
+
+			It("rejects a malformed --wait-for value", func() {
+				args := []string{"cool-web-app", "superfun/app", "--wait-for=bogus", "--", "/start-me-please"}
+				appExaminer.RunningAppInstancesInfoReturns(1, false, nil)
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				Expect(outputBuffer).To(test_helpers.Say(command_factory.MalformedWaitForErrorMessage))
+			})
+		})
+
+		Describe("--from-json", func() {
+			var specPath string
+
+			BeforeEach(func() {
+				tmpFile, err := ioutil.TempFile("", "lattice-spec")
+				Expect(err).NotTo(HaveOccurred())
+				specPath = tmpFile.Name()
+				tmpFile.Close()
+				appExaminer.RunningAppInstancesInfoReturns(1, false, nil)
+			})
+
+			AfterEach(func() {
+				os.Remove(specPath)
+			})
+
+			It("creates the app described by the spec", func() {
+				Expect(ioutil.WriteFile(specPath, []byte(`{
+					"schemaVersion": 1,
+					"name": "cool-web-app",
+					"image": "superfun/app:mycooltag",
+					"instances": 2
+				}`), 0644)).To(Succeed())
+				appExaminer.RunningAppInstancesInfoReturns(2, false, nil)
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, []string{"--from-json=" + specPath})
+
+				Expect(dockerRunner.CreateDockerAppCallCount()).To(Equal(1))
+				createDockerAppParameters := dockerRunner.CreateDockerAppArgsForCall(0)
+				Expect(createDockerAppParameters.Name).To(Equal("cool-web-app"))
+				Expect(createDockerAppParameters.RootFS).To(Equal("superfun/app:mycooltag"))
+				Expect(createDockerAppParameters.Instances).To(Equal(2))
+				Expect(outputBuffer).To(test_helpers.Say(colors.Green("cool-web-app is now running.\n")))
+			})
+
+			It("defaults instances, cpu-weight and working-dir when the spec omits them", func() {
+				Expect(ioutil.WriteFile(specPath, []byte(`{
+					"schemaVersion": 1,
+					"name": "cool-web-app",
+					"image": "superfun/app:mycooltag"
+				}`), 0644)).To(Succeed())
+				dockerMetadataFetcher.FetchMetadataReturns(&docker_metadata_fetcher.ImageMetadata{}, nil)
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, []string{"--from-json=" + specPath})
+
+				createDockerAppParameters := dockerRunner.CreateDockerAppArgsForCall(0)
+				Expect(createDockerAppParameters.Instances).To(Equal(1))
+				Expect(createDockerAppParameters.CPUWeight).To(Equal(uint(100)))
+				Expect(createDockerAppParameters.WorkingDir).To(Equal("/"))
+			})
+
+			It("skips creation and prints the resolved spec with --dry-run", func() {
+				Expect(ioutil.WriteFile(specPath, []byte(`{
+					"schemaVersion": 1,
+					"name": "cool-web-app",
+					"image": "superfun/app:mycooltag"
+				}`), 0644)).To(Succeed())
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, []string{"--from-json=" + specPath, "--dry-run"})
+
+				Expect(dockerRunner.CreateDockerAppCallCount()).To(Equal(0))
+				Expect(outputBuffer).To(test_helpers.Say("cool-web-app"))
+			})
+
+			It("overrides spec fields with flags passed alongside --from-json", func() {
+				Expect(ioutil.WriteFile(specPath, []byte(`{
+					"schemaVersion": 1,
+					"name": "cool-web-app",
+					"image": "superfun/app:mycooltag",
+					"instances": 2
+				}`), 0644)).To(Succeed())
+				appExaminer.RunningAppInstancesInfoReturns(5, false, nil)
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, []string{"--from-json=" + specPath, "--instances=5"})
+
+				createDockerAppParameters := dockerRunner.CreateDockerAppArgsForCall(0)
+				Expect(createDockerAppParameters.Instances).To(Equal(5))
+			})
+
+			It("rejects an unsupported schemaVersion", func() {
+				Expect(ioutil.WriteFile(specPath, []byte(`{"schemaVersion": 2, "image": "superfun/app"}`), 0644)).To(Succeed())
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, []string{"--from-json=" + specPath})
+
+				Expect(dockerRunner.CreateDockerAppCallCount()).To(Equal(0))
+				Expect(outputBuffer).To(test_helpers.Say(command_factory.UnsupportedSchemaVersionErrorMessage))
+				Expect(fakeExitHandler.ExitCalledWith).To(Equal([]int{exit_codes.InvalidSyntax}))
+			})
+		})
+
+		Describe("Pull Policy and Digest Pinning", func() {
+			BeforeEach(func() {
+				appExaminer.RunningAppInstancesInfoReturns(1, false, nil)
+			})
+
+			It("embeds the registry-resolved digest into RootFS by default", func() {
+				dockerMetadataFetcher.FetchMetadataReturns(&docker_metadata_fetcher.ImageMetadata{Digest: "sha256:abcd"}, nil)
+				args := []string{"cool-web-app", "superfun/app:latest", "--", "/start-me-please"}
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				Expect(dockerRunner.CreateDockerAppArgsForCall(0).RootFS).To(Equal("docker:///superfun/app@sha256:abcd"))
+			})
+
+			It("fails with BadDocker when --image-digest mismatches the registry digest under --pull-policy=always", func() {
+				dockerMetadataFetcher.FetchMetadataReturns(&docker_metadata_fetcher.ImageMetadata{Digest: "sha256:abcd"}, nil)
+				args := []string{"cool-web-app", "superfun/app:latest", "--image-digest=sha256:efgh", "--", "/start-me-please"}
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				Expect(dockerRunner.CreateDockerAppCallCount()).To(Equal(0))
+				Expect(outputBuffer).To(test_helpers.Say(command_factory.DigestMismatchErrorMessage))
+				Expect(fakeExitHandler.ExitCalledWith).To(Equal([]int{exit_codes.BadDocker}))
+			})
+
+			It("skips the registry digest and uses --image-digest verbatim under --pull-policy=missing", func() {
+				dockerMetadataFetcher.FetchMetadataReturns(&docker_metadata_fetcher.ImageMetadata{}, nil)
+				args := []string{"cool-web-app", "superfun/app:latest", "--pull-policy=missing", "--image-digest=sha256:efgh", "--", "/start-me-please"}
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				Expect(dockerRunner.CreateDockerAppArgsForCall(0).RootFS).To(Equal("docker:///superfun/app@sha256:efgh"))
+			})
+
+			It("fails with BadDocker under --pull-policy=never when there is no cached digest", func() {
+				dockerMetadataFetcher.FetchMetadataReturns(&docker_metadata_fetcher.ImageMetadata{}, nil)
+				args := []string{"cool-web-app", "superfun/app:latest", "--pull-policy=never", "--", "/start-me-please"}
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				Expect(dockerRunner.CreateDockerAppCallCount()).To(Equal(0))
+				Expect(outputBuffer).To(test_helpers.Say(command_factory.NoCachedDigestErrorMessage))
+				Expect(fakeExitHandler.ExitCalledWith).To(Equal([]int{exit_codes.BadDocker}))
+			})
+
+			It("rejects an invalid --pull-policy value", func() {
+				args := []string{"cool-web-app", "superfun/app:latest", "--pull-policy=sometimes", "--", "/start-me-please"}
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				Expect(dockerRunner.CreateDockerAppCallCount()).To(Equal(0))
+				Expect(outputBuffer).To(test_helpers.Say(command_factory.InvalidPullPolicyErrorMessage))
+				Expect(fakeExitHandler.ExitCalledWith).To(Equal([]int{exit_codes.InvalidSyntax}))
+			})
+
+			Describe("--pin-digest", func() {
+				It("resolves the tag to a digest, prints it, and pins RootFS to it", func() {
+					dockerMetadataFetcher.FetchMetadataReturns(&docker_metadata_fetcher.ImageMetadata{}, nil)
+					dockerMetadataFetcher.FetchDigestReturns("sha256:abcd", nil)
+					args := []string{"cool-web-app", "superfun/app:latest", "--pin-digest", "--", "/start-me-please"}
+
+					test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+					Expect(dockerMetadataFetcher.FetchDigestCallCount()).To(Equal(1))
+					repo, tag := dockerMetadataFetcher.FetchDigestArgsForCall(0)
+					Expect(repo).To(Equal("superfun/app"))
+					Expect(tag).To(Equal("latest"))
+
+					Expect(outputBuffer).To(test_helpers.Say("Resolved superfun/app:latest -> superfun/app@sha256:abcd\n"))
+					Expect(dockerRunner.CreateDockerAppArgsForCall(0).RootFS).To(Equal("docker:///superfun/app@sha256:abcd"))
+				})
+
+				It("defaults the tag to latest when the image reference omits one", func() {
+					dockerMetadataFetcher.FetchMetadataReturns(&docker_metadata_fetcher.ImageMetadata{}, nil)
+					dockerMetadataFetcher.FetchDigestReturns("sha256:abcd", nil)
+					args := []string{"cool-web-app", "superfun/app", "--pin-digest", "--", "/start-me-please"}
+
+					test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+					_, tag := dockerMetadataFetcher.FetchDigestArgsForCall(0)
+					Expect(tag).To(Equal("latest"))
+				})
+
+				It("fails with BadDocker when the digest cannot be resolved", func() {
+					dockerMetadataFetcher.FetchMetadataReturns(&docker_metadata_fetcher.ImageMetadata{}, nil)
+					dockerMetadataFetcher.FetchDigestReturns("", errors.New("registry unreachable"))
+					args := []string{"cool-web-app", "superfun/app:latest", "--pin-digest", "--", "/start-me-please"}
+
+					test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+					Expect(dockerRunner.CreateDockerAppCallCount()).To(Equal(0))
+					Expect(outputBuffer).To(test_helpers.Say("Error resolving digest for image: registry unreachable"))
+					Expect(fakeExitHandler.ExitCalledWith).To(Equal([]int{exit_codes.BadDocker}))
+				})
+			})
+		})
+
+		Describe("User", func() {
+			BeforeEach(func() {
+				appExaminer.RunningAppInstancesInfoReturns(1, false, nil)
+			})
+
+			It("uses the default (empty) user when nothing is set", func() {
+				args := []string{"cool-web-app", "superfun/app", "--", "/start-me-please"}
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				Expect(dockerRunner.CreateDockerAppArgsForCall(0).User).To(Equal(""))
+			})
+
+			It("uses --user when provided", func() {
+				args := []string{"cool-web-app", "superfun/app", "--user=appuser", "--", "/start-me-please"}
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				Expect(dockerRunner.CreateDockerAppArgsForCall(0).User).To(Equal("appuser"))
+			})
+
+			It("falls back to the image metadata user when --user is not set", func() {
+				dockerMetadataFetcher.FetchMetadataReturns(&docker_metadata_fetcher.ImageMetadata{User: "metadatauser"}, nil)
+				args := []string{"cool-web-app", "superfun/app", "--", "/start-me-please"}
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				Expect(dockerRunner.CreateDockerAppArgsForCall(0).User).To(Equal("metadatauser"))
+			})
+
+			It("prefers --user over the image metadata user", func() {
+				dockerMetadataFetcher.FetchMetadataReturns(&docker_metadata_fetcher.ImageMetadata{User: "metadatauser"}, nil)
+				args := []string{"cool-web-app", "superfun/app", "--user=appuser", "--", "/start-me-please"}
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				Expect(dockerRunner.CreateDockerAppArgsForCall(0).User).To(Equal("appuser"))
+			})
+
+			It("rejects --user=root combined with --run-as-root=false", func() {
+				args := []string{"cool-web-app", "superfun/app", "--user=root", "--run-as-root=false", "--", "/start-me-please"}
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				Expect(dockerRunner.CreateDockerAppCallCount()).To(Equal(0))
+				Expect(outputBuffer).To(test_helpers.Say(command_factory.UserConflictsWithRunAsRootErrorMessage))
+				Expect(fakeExitHandler.ExitCalledWith).To(Equal([]int{exit_codes.InvalidSyntax}))
+			})
+
+			It("rejects --user combined with --run-as-root even when not contradictory", func() {
+				args := []string{"cool-web-app", "superfun/app", "--user=appuser", "--run-as-root", "--", "/start-me-please"}
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				Expect(dockerRunner.CreateDockerAppCallCount()).To(Equal(0))
+				Expect(outputBuffer).To(test_helpers.Say(command_factory.UserConflictsWithRunAsRootErrorMessage))
+				Expect(fakeExitHandler.ExitCalledWith).To(Equal([]int{exit_codes.InvalidSyntax}))
+			})
+
+			It("treats --run-as-root as an alias for --user=root", func() {
+				args := []string{"cool-web-app", "superfun/app", "--run-as-root", "--", "/start-me-please"}
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				createDockerAppParameters := dockerRunner.CreateDockerAppArgsForCall(0)
+				Expect(createDockerAppParameters.User).To(Equal("root"))
+				Expect(createDockerAppParameters.Privileged).To(BeFalse())
+			})
+
+			It("only sets Privileged via --privileged, independent of the user", func() {
+				args := []string{"cool-web-app", "superfun/app", "--user=appuser", "--privileged", "--", "/start-me-please"}
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				createDockerAppParameters := dockerRunner.CreateDockerAppArgsForCall(0)
+				Expect(createDockerAppParameters.User).To(Equal("appuser"))
+				Expect(createDockerAppParameters.Privileged).To(BeTrue())
+			})
+		})
+
+		Describe("TCP and HTTP routes", func() {
+			BeforeEach(func() {
+				appExaminer.RunningAppInstancesInfoReturns(1, false, nil)
+			})
+
+			It("registers http routes via --http-route, superseding --routes", func() {
+				args := []string{
+					"cool-web-app",
+					"superfun/app",
+					"--routes=3000:ignored-route",
+					"--http-route=3000:route-3000-yay",
+					"--",
+					"/start-me-please",
+				}
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				createDockerAppParameters := dockerRunner.CreateDockerAppArgsForCall(0)
+				Expect(createDockerAppParameters.RouteOverrides).To(ContainExactly(app_runner.RouteOverrides{
+					app_runner.RouteOverride{HostnamePrefix: "route-3000-yay", Port: 3000},
+				}))
+				Expect(outputBuffer).ToNot(test_helpers.Say(command_factory.RoutesDeprecationMessage))
+			})
+
+			It("prints a deprecation notice when --routes is used", func() {
+				args := []string{
+					"cool-web-app",
+					"superfun/app",
+					"--routes=3000:route-3000-yay",
+					"--",
+					"/start-me-please",
+				}
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				Expect(outputBuffer).To(test_helpers.Say(command_factory.RoutesDeprecationMessage))
+			})
+
+			It("registers tcp routes via --tcp-route and prints them alongside http routes", func() {
+				args := []string{
+					"cool-web-app",
+					"superfun/app",
+					"--ports=5432",
+					"--tcp-route=50000:5432",
+					"--",
+					"/start-me-please",
+				}
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				createDockerAppParameters := dockerRunner.CreateDockerAppArgsForCall(0)
+				Expect(createDockerAppParameters.TCPRoutes).To(Equal([]route_helpers.TCPRoute{
+					{ExternalPort: 50000, ContainerPort: 5432},
+				}))
+				Expect(outputBuffer).To(test_helpers.Say(colors.Green("tcp://192.168.11.11.xip.io:50000 -> container:5432\n")))
+			})
+
+			It("errors out when the tcp route's container port is not exposed", func() {
+				args := []string{
+					"cool-web-app",
+					"superfun/app",
+					"--ports=5432",
+					"--tcp-route=50000:5433",
+					"--",
+					"/start-me-please",
+				}
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				Expect(dockerRunner.CreateDockerAppCallCount()).To(Equal(0))
+				Expect(outputBuffer).To(test_helpers.Say(command_factory.TCPRoutePortNotExposed))
+				Expect(fakeExitHandler.ExitCalledWith).To(Equal([]int{exit_codes.CommandFailed}))
+			})
+
+			It("errors out when two --tcp-route flags claim the same external port", func() {
+				args := []string{
+					"cool-web-app",
+					"superfun/app",
+					"--ports=5432,5433",
+					"--tcp-route=50000:5432",
+					"--tcp-route=50000:5433",
+					"--",
+					"/start-me-please",
+				}
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				Expect(dockerRunner.CreateDockerAppCallCount()).To(Equal(0))
+				Expect(outputBuffer).To(test_helpers.Say(command_factory.TCPRouteExternalPortCollisionErrorMessage))
+				Expect(fakeExitHandler.ExitCalledWith).To(Equal([]int{exit_codes.InvalidSyntax}))
+			})
+
+			It("errors out when a --tcp-route external port collides with a reserved HTTP router port", func() {
+				args := []string{
+					"cool-web-app",
+					"superfun/app",
+					"--ports=5432",
+					"--tcp-route=80:5432",
+					"--",
+					"/start-me-please",
+				}
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				Expect(dockerRunner.CreateDockerAppCallCount()).To(Equal(0))
+				Expect(outputBuffer).To(test_helpers.Say(command_factory.TCPRouteExternalPortCollisionErrorMessage))
+				Expect(fakeExitHandler.ExitCalledWith).To(Equal([]int{exit_codes.InvalidSyntax}))
+			})
+		})
+
 		Context("when no working dir is provided, but the metadata has a working dir", func() {
 			It("sets the working dir from the Docker metadata", func() {
 				args := []string{
@@ -759,6 +1458,31 @@ var _ = Describe("CommandFactory", func() {
 				})
 			})
 
+			Context("when the app does not start before the timeout elapses with --monitor-command", func() {
+				It("hints that the command healthcheck may be failing", func() {
+					args := []string{
+						"cool-web-app",
+						"superfun/app",
+						"--monitor-command=/bin/check",
+						"--",
+						"/start-me-please",
+					}
+					dockerMetadataFetcher.FetchMetadataReturns(&docker_metadata_fetcher.ImageMetadata{}, nil)
+					appExaminer.RunningAppInstancesInfoReturns(0, false, nil)
+
+					commandFinishChan := test_helpers.AsyncExecuteCommandWithArgs(createCommand, args)
+
+					Eventually(outputBuffer).Should(test_helpers.Say("Creating App: cool-web-app"))
+
+					clock.IncrementBySeconds(120)
+
+					Eventually(commandFinishChan).Should(BeClosed())
+
+					Expect(outputBuffer).To(test_helpers.Say(colors.Red("Timed out waiting for the container to come up.")))
+					Expect(outputBuffer).To(test_helpers.SayLine("This can happen because docker layers can take time to download, or because --monitor-command is exiting non-zero."))
+				})
+			})
+
 			Context("when there is a placement error when polling for the app to start", func() {
 				It("prints an error message and exits", func() {
 					args := []string{
@@ -799,6 +1523,145 @@ var _ = Describe("CommandFactory", func() {
 			})
 		})
 
+		Describe("--diagnose-placement", func() {
+			It("renders the cell distribution alongside the placement error when set", func() {
+				args := []string{"--diagnose-placement", "cool-web-app", "superfun/app", "--", "/start-me-please"}
+				dockerMetadataFetcher.FetchMetadataReturns(&docker_metadata_fetcher.ImageMetadata{}, nil)
+				appExaminer.RunningAppInstancesInfoReturns(0, true, nil)
+				appExaminer.ListCellsReturns([]app_examiner.CellInfo{
+					{CellID: "cell-0", RunningInstances: 2, ClaimedInstances: 1},
+					{CellID: "cell-1", Missing: true},
+					{CellID: "cell-2"},
+				}, nil)
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				Expect(fakeExitHandler.ExitCalledWith).To(Equal([]int{exit_codes.PlacementError}))
+				Expect(outputBuffer).To(test_helpers.Say(colors.Red("Error, could not place all instances: insufficient resources. Try requesting fewer instances or reducing the requested memory or disk capacity.")))
+				Expect(outputBuffer).To(test_helpers.Say("cell-0: " + colors.Green("●") + colors.Green("●") + colors.Yellow("●") + "\n"))
+				Expect(outputBuffer).To(test_helpers.Say(colors.Red("cell-1 [MISSING]\n")))
+				Expect(outputBuffer).To(test_helpers.Say("cell-2: " + colors.Red("empty") + "\n"))
+			})
+
+			It("omits the cell distribution when not set and stdout is not a terminal", func() {
+				args := []string{"cool-web-app", "superfun/app", "--", "/start-me-please"}
+				dockerMetadataFetcher.FetchMetadataReturns(&docker_metadata_fetcher.ImageMetadata{}, nil)
+				appExaminer.RunningAppInstancesInfoReturns(0, true, nil)
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				Expect(appExaminer.ListCellsCallCount()).To(Equal(0))
+				Expect(outputBuffer).To(test_helpers.Say(colors.Red("Error, could not place all instances: insufficient resources. Try requesting fewer instances or reducing the requested memory or disk capacity.")))
+			})
+
+			It("is skipped when explicitly disabled", func() {
+				args := []string{"--diagnose-placement=false", "cool-web-app", "superfun/app", "--", "/start-me-please"}
+				dockerMetadataFetcher.FetchMetadataReturns(&docker_metadata_fetcher.ImageMetadata{}, nil)
+				appExaminer.RunningAppInstancesInfoReturns(0, true, nil)
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				Expect(appExaminer.ListCellsCallCount()).To(Equal(0))
+			})
+		})
+
+		Describe("--ssh", func() {
+			It("connects to the requested instance once the app is running", func() {
+				args := []string{"--ssh", "--ssh-instance=2", "cool-web-app", "superfun/app", "--", "/start-me-please"}
+				dockerMetadataFetcher.FetchMetadataReturns(&docker_metadata_fetcher.ImageMetadata{}, nil)
+				appExaminer.RunningAppInstancesInfoReturns(1, false, nil)
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				Expect(fakeSSHConnector.connectCallCount).To(Equal(1))
+				Expect(fakeSSHConnector.connectAppName).To(Equal("cool-web-app"))
+				Expect(fakeSSHConnector.connectInstanceIndex).To(Equal(2))
+			})
+
+			It("does not connect when the app times out before running", func() {
+				args := []string{"--ssh", "cool-web-app", "superfun/app", "--", "/start-me-please"}
+				dockerMetadataFetcher.FetchMetadataReturns(&docker_metadata_fetcher.ImageMetadata{}, nil)
+				appExaminer.RunningAppInstancesInfoReturns(0, false, nil)
+
+				commandFinishChan := test_helpers.AsyncExecuteCommandWithArgs(createCommand, args)
+				Eventually(outputBuffer).Should(test_helpers.Say("Creating App: cool-web-app"))
+
+				clock.IncrementBySeconds(120)
+
+				Eventually(commandFinishChan).Should(BeClosed())
+				Expect(fakeSSHConnector.connectCallCount).To(Equal(0))
+				Expect(outputBuffer).To(test_helpers.Say("Not dropping into a shell: cool-web-app never reached a running state."))
+			})
+
+			It("does not connect on a placement error", func() {
+				args := []string{"--ssh", "cool-web-app", "superfun/app", "--", "/start-me-please"}
+				dockerMetadataFetcher.FetchMetadataReturns(&docker_metadata_fetcher.ImageMetadata{}, nil)
+				appExaminer.RunningAppInstancesInfoReturns(0, true, nil)
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				Expect(fakeSSHConnector.connectCallCount).To(Equal(0))
+				Expect(outputBuffer).To(test_helpers.Say("Not dropping into a shell: cool-web-app never reached a running state."))
+			})
+
+			It("still connects when --no-routes is also passed", func() {
+				args := []string{"--ssh", "--no-routes", "cool-web-app", "superfun/app", "--", "/start-me-please"}
+				dockerMetadataFetcher.FetchMetadataReturns(&docker_metadata_fetcher.ImageMetadata{}, nil)
+				appExaminer.RunningAppInstancesInfoReturns(1, false, nil)
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				Expect(fakeSSHConnector.connectCallCount).To(Equal(1))
+				Expect(fakeSSHConnector.connectAppName).To(Equal("cool-web-app"))
+			})
+		})
+
+		Describe("--route-service-url", func() {
+			It("passes the route service URL through to CreateDockerApp and prints it in the summary", func() {
+				args := []string{"--route-service-url=https://auth.example.com", "cool-web-app", "superfun/app", "--", "/start-me-please"}
+				dockerMetadataFetcher.FetchMetadataReturns(&docker_metadata_fetcher.ImageMetadata{}, nil)
+				appExaminer.RunningAppInstancesInfoReturns(1, false, nil)
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				createDockerAppParameters := dockerRunner.CreateDockerAppArgsForCall(0)
+				Expect(createDockerAppParameters.RouteServiceURL).To(Equal("https://auth.example.com"))
+				Expect(outputBuffer).To(test_helpers.Say(colors.Green("Routed through: https://auth.example.com\n")))
+			})
+
+			It("allows an explicit empty value to leave no route service bound", func() {
+				args := []string{"--route-service-url=", "cool-web-app", "superfun/app", "--", "/start-me-please"}
+				dockerMetadataFetcher.FetchMetadataReturns(&docker_metadata_fetcher.ImageMetadata{}, nil)
+				appExaminer.RunningAppInstancesInfoReturns(1, false, nil)
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				createDockerAppParameters := dockerRunner.CreateDockerAppArgsForCall(0)
+				Expect(createDockerAppParameters.RouteServiceURL).To(Equal(""))
+				Expect(outputBuffer).ToNot(test_helpers.Say("Routed through:"))
+			})
+
+			It("rejects a non-https URL", func() {
+				args := []string{"--route-service-url=http://auth.example.com", "cool-web-app", "superfun/app", "--", "/start-me-please"}
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				Expect(dockerRunner.CreateDockerAppCallCount()).To(Equal(0))
+				Expect(outputBuffer).To(test_helpers.Say(command_factory.InvalidRouteServiceURLErrorMessage))
+				Expect(fakeExitHandler.ExitCalledWith).To(Equal([]int{exit_codes.InvalidSyntax}))
+			})
+
+			It("rejects a malformed URL", func() {
+				args := []string{"--route-service-url=https://", "cool-web-app", "superfun/app", "--", "/start-me-please"}
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				Expect(dockerRunner.CreateDockerAppCallCount()).To(Equal(0))
+				Expect(outputBuffer).To(test_helpers.Say(command_factory.InvalidRouteServiceURLErrorMessage))
+				Expect(fakeExitHandler.ExitCalledWith).To(Equal([]int{exit_codes.InvalidSyntax}))
+			})
+		})
+
 		Context("invalid syntax", func() {
 			It("validates the CPU weight is in 1-100", func() {
 				args := []string{
@@ -836,6 +1699,22 @@ var _ = Describe("CommandFactory", func() {
 				Expect(outputBuffer).To(test_helpers.Say("Incorrect Usage: '--' Required before start command"))
 				Expect(dockerRunner.CreateDockerAppCallCount()).To(Equal(0))
 			})
+
+			It("validates that --no-monitor and --monitor-command are not both set", func() {
+				args := []string{
+					"cool-web-app",
+					"superfun/app",
+					"--no-monitor",
+					"--monitor-command=/bin/check",
+					"--",
+					"/start-me-please",
+				}
+
+				test_helpers.ExecuteCommandWithArgs(createCommand, args)
+
+				Expect(outputBuffer).To(test_helpers.Say("Incorrect Usage: " + command_factory.NoMonitorConflictsWithMonitorCommandErrorMessage))
+				Expect(dockerRunner.CreateDockerAppCallCount()).To(Equal(0))
+			})
 		})
 
 		Context("when the app runner returns an error", func() {
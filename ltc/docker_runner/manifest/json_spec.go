@@ -0,0 +1,29 @@
+package manifest
+
+import (
+	"encoding/json"
+)
+
+// CurrentSchemaVersion is the only schemaVersion this package knows how to
+// read. Bump it (and add a migration) if the JSONSpec shape ever changes.
+const CurrentSchemaVersion = 1
+
+// JSONSpec is the versioned, source-controllable shape accepted by
+// `ltc create --from-json`. Exposed ports, working directory, user and
+// environment omitted from the file fall back to docker metadata; instances,
+// CPU weight, memory and working directory fall back to the usual create
+// defaults. An omitted monitor block leaves the app unmonitored.
+type JSONSpec struct {
+	SchemaVersion int `json:"schemaVersion"`
+	AppSpec
+}
+
+// ParseJSON reads a single app spec from JSON-encoded bytes. Input is decoded
+// with encoding/json only, so YAML that is not also valid JSON is rejected.
+func ParseJSON(data []byte) (*JSONSpec, error) {
+	var spec JSONSpec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
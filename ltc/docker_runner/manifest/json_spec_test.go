@@ -0,0 +1,31 @@
+package manifest_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cloudfoundry-incubator/lattice/ltc/docker_runner/manifest"
+)
+
+var _ = Describe("ParseJSON", func() {
+	It("parses a versioned, partial spec", func() {
+		jsonBytes := []byte(`{
+			"schemaVersion": 1,
+			"name": "cool-web-app",
+			"image": "superfun/app:mycooltag",
+			"instances": 3
+		}`)
+
+		spec, err := manifest.ParseJSON(jsonBytes)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(spec.SchemaVersion).To(Equal(1))
+		Expect(spec.Name).To(Equal("cool-web-app"))
+		Expect(spec.Image).To(Equal("superfun/app:mycooltag"))
+		Expect(spec.Instances).To(Equal(3))
+	})
+
+	It("errors out on malformed JSON", func() {
+		_, err := manifest.ParseJSON([]byte("{not valid json"))
+		Expect(err).To(HaveOccurred())
+	})
+})
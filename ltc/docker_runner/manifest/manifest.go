@@ -0,0 +1,71 @@
+// Package manifest parses the compose-style YAML app manifests accepted by
+// `ltc create --manifest`.
+package manifest
+
+import (
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+type MonitorSpec struct {
+	Port    uint16 `yaml:"port,omitempty" json:"port,omitempty"`
+	URL     string `yaml:"url,omitempty" json:"url,omitempty"`
+	Command string `yaml:"command,omitempty" json:"command,omitempty"`
+	None    bool   `yaml:"none,omitempty" json:"none,omitempty"`
+}
+
+type AppSpec struct {
+	Name       string            `yaml:"name,omitempty" json:"name,omitempty"`
+	Image      string            `yaml:"image" json:"image,omitempty"`
+	Env        map[string]string `yaml:"env,omitempty" json:"env,omitempty"`
+	Ports      []uint16          `yaml:"ports,omitempty" json:"ports,omitempty"`
+	Routes     []string          `yaml:"routes,omitempty" json:"routes,omitempty"`
+	Monitor    MonitorSpec       `yaml:"monitor,omitempty" json:"monitor,omitempty"`
+	MemoryMB   int               `yaml:"memory_mb,omitempty" json:"memoryMB,omitempty"`
+	DiskMB     int               `yaml:"disk_mb,omitempty" json:"diskMB,omitempty"`
+	CPUWeight  uint              `yaml:"cpu_weight,omitempty" json:"cpuWeight,omitempty"`
+	Instances  int               `yaml:"instances,omitempty" json:"instances,omitempty"`
+	WorkingDir string            `yaml:"working_dir,omitempty" json:"workingDir,omitempty"`
+	User       string            `yaml:"user,omitempty" json:"user,omitempty"`
+}
+
+// Manifest is either a single app (the top-level fields) or a collection of
+// apps under a `services:` map. A manifest must not mix both forms; Parse
+// does not reject one that does, and Apps then ignores the top-level fields.
+type Manifest struct {
+	AppSpec  `yaml:",inline"`
+	Services map[string]AppSpec `yaml:"services,omitempty"`
+}
+
+// Parse reads a manifest from YAML-encoded bytes.
+func Parse(data []byte) (*Manifest, error) {
+	var parsed Manifest
+	if err := yaml.Unmarshal(data, &parsed); err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
+
+// Apps returns the individual app specs described by the manifest, applying
+// each service's map key as its name when the manifest uses `services:`.
+// Services are returned sorted by name.
+func (manifest *Manifest) Apps() []AppSpec {
+	if len(manifest.Services) == 0 {
+		return []AppSpec{manifest.AppSpec}
+	}
+
+	names := make([]string, 0, len(manifest.Services))
+	for name := range manifest.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	apps := make([]AppSpec, 0, len(manifest.Services))
+	for _, name := range names {
+		spec := manifest.Services[name]
+		spec.Name = name
+		apps = append(apps, spec)
+	}
+	return apps
+}
@@ -0,0 +1,74 @@
+package manifest_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/cloudfoundry-incubator/lattice/ltc/docker_runner/manifest"
+)
+
+var _ = Describe("Manifest", func() {
+	Describe("Parse", func() {
+		It("parses a single-app manifest", func() {
+			yml := []byte(`
+image: superfun/app:latest
+instances: 3
+monitor:
+  port: 8080
+`)
+			parsed, err := manifest.Parse(yml)
+			Expect(err).NotTo(HaveOccurred())
+
+			apps := parsed.Apps()
+			Expect(apps).To(HaveLen(1))
+			Expect(apps[0].Image).To(Equal("superfun/app:latest"))
+			Expect(apps[0].Instances).To(Equal(3))
+			Expect(apps[0].Monitor.Port).To(Equal(uint16(8080)))
+		})
+
+		It("parses a multi-app manifest under services:", func() {
+			yml := []byte(`
+services:
+  web:
+    image: superfun/web:latest
+    instances: 2
+  worker:
+    image: superfun/worker:latest
+    instances: 1
+`)
+			parsed, err := manifest.Parse(yml)
+			Expect(err).NotTo(HaveOccurred())
+
+			apps := parsed.Apps()
+			Expect(apps).To(HaveLen(2))
+
+			names := []string{apps[0].Name, apps[1].Name}
+			Expect(names).To(ConsistOf("web", "worker"))
+		})
+
+		It("round-trips through Marshal and Parse", func() {
+			original := manifest.AppSpec{
+				Name:       "cool-web-app",
+				Image:      "superfun/app:mycooltag",
+				Env:        map[string]string{"COLOR": "Blue"},
+				Ports:      []uint16{8080},
+				Instances:  2,
+				WorkingDir: "/applications",
+			}
+
+			data, err := yaml.Marshal(original)
+			Expect(err).NotTo(HaveOccurred())
+
+			parsed, err := manifest.Parse(data)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(parsed.Apps()).To(Equal([]manifest.AppSpec{original}))
+		})
+
+		It("errors out on malformed YAML", func() {
+			_, err := manifest.Parse([]byte("not: [valid"))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
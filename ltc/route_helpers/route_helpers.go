@@ -0,0 +1,7 @@
+package route_helpers
+
+// TCPRoute maps a container port to a router-assigned external TCP port.
+type TCPRoute struct {
+	ContainerPort uint16 `json:"container_port"`
+	ExternalPort  uint16 `json:"external_port"`
+}